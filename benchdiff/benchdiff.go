@@ -4,24 +4,89 @@
 // Usage:
 //   cd path/to/git/repo
 //   benchdiff -before b1 -after b2
+//   benchdiff -revs a,b,c
+//   benchdiff old.txt new.txt
+//   benchdiff bisect -good REV -bad REV -bench RE -threshold 10%
 //
-// The names b1 and b2 must be branches or something else that can be the
-// target of "git checkout".
+// The names b1 and b2 must be branches or other revisions that can be checked
+// out by "git worktree add". Each revision is checked out into a temporary
+// worktree, so the current working tree is not modified, and the test binaries
+// for both are built before any benchmarks are run. Use -save to keep the raw
+// output of each run for later comparison.
+//
+// The -beforetest and -aftertest flags may be package patterns such as
+// "./...", in which case the benchmarks of all matching packages are run.
+// Results are keyed by package and benchmark name, and the report is grouped
+// by package, with a geometric mean summary for each package.
+//
+// Given two file arguments, benchdiff instead compares the output of previous
+// benchmark runs saved in those files, such as "go test -bench" output saved
+// by CI or by -save, without running anything.
+//
+// To compare more than two revisions, use -revs to give a list of revisions
+// or a commit range. A range "a..b" includes a and each commit after it up to
+// and including b. Given -revs, or more than two files, the report is a matrix
+// showing each benchmark across all the revisions, with deltas relative to the
+// -baseline revision.
+//
+// With -count N, the before and after benchmarks are run N times each,
+// interleaved to reduce bias from drift in machine conditions. The report
+// shows the median and spread of the samples. Differences are tested for
+// significance with a Mann-Whitney U test, and those that are not significant
+// at the level given by -alpha are reported as "~". With fewer than 4 runs no
+// difference can be significant at the default -alpha, and benchdiff warns.
+//
+// The report has a section for each metric reported by the benchmarks,
+// including ns/op, MB/s, B/op, allocs/op, and any custom metrics reported via
+// b.ReportMetric. The GAIN column gives the percentage improvement from before
+// to after: Positive values are better, negative values are worse. For rates
+// (units ending in "/s"), higher values are better; for all other units, lower
+// values are better.
+//
+// Use -benchtime, -cpu, and -benchmem to pass the corresponding flags to the
+// test binaries, and -tags and -gcflags to pass build flags to "go test". Use
+// -env to set environment variables such as GOMAXPROCS, GOEXPERIMENT, or
+// GOFLAGS for building and running. The -beforebuild, -afterbuild,
+// -beforeenv, and -afterenv flags add build flags and environment for one side
+// only; with these, -before and -after may name the same revision, to compare
+// two configurations of the same code. If the sides differ only in GOMAXPROCS,
+// benchmarks are matched without the -N suffix of their names.
+//
+// With -profile, each benchmark that regressed beyond the -wash level is rerun
+// on both revisions with CPU and memory profiling enabled, and benchdiff reports
+// the functions whose flat and cumulative share of each profile changed most.
+// Only samples taken within the benchmark are counted, and every allocation
+// is recorded. If -save is set, the profiles are also saved there.
+//
+// The "bisect" subcommand searches the history between a good and a bad
+// revision for the first commit at which a benchmark regresses by more than a
+// threshold. Run "benchdiff bisect -help" for details.
+//
+// Use -o to select JSON, CSV, or Markdown output instead of a table. Use
+// -fail-on-regression to exit with an error if any metric gets worse by more
+// than a threshold, either for all units ("5%") or per unit ("ns/op=5%").
+// With -count N > 1 only significant regressions are counted, so N must be
+// large enough that a difference can be significant (4 or more at the default
+// -alpha); otherwise benchdiff reports an error rather than always passing.
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"os"
-	"os/exec"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
-	"text/tabwriter"
+	"syscall"
 	"time"
+
+	"bitbucket.org/creachadair/shell"
 )
 
 var (
@@ -32,197 +97,493 @@ var (
 	benchPattern = flag.String("match", ".", "Run benchmarks matching this regexp")
 	washLevel    = flag.Float64("wash", 2, "Percentage below which differences are a wash")
 	ignoreErr    = flag.Bool("ignore-error", false, "Ignore errors from the test runner")
+	runCount     = flag.Int("count", 1, "Run each benchmark this many times")
+	alpha        = flag.Float64("alpha", 0.05, "Significance level for differences when -count > 1")
+	outFormat    = flag.String("o", "text", "Output format (text, json, csv, or markdown)")
+	failOn       = flag.String("fail-on-regression", "", `Fail if any metric regresses by more than this ("5%" or "ns/op=5%,B/op=0")`)
+	doProfile    = flag.Bool("profile", false, "Profile regressed benchmarks and report the functions that changed most")
+	profileTop   = flag.Int("profile-top", 10, "Number of functions to report for each profile with -profile")
+	saveDir      = flag.String("save", "", "Save the raw benchmark output for each revision in this directory")
+	benchTime    = flag.String("benchtime", "", "Run each benchmark for this duration or count (passed to -test.benchtime)")
+	cpuList      = flag.String("cpu", "", "Run benchmarks with these GOMAXPROCS values (passed to -test.cpu)")
+	benchMem     = flag.Bool("benchmem", false, "Report memory allocation statistics (passed to -test.benchmem)")
+	buildTags    = flag.String("tags", "", "Build tags for all revisions (passed to go test -tags)")
+	gcFlags      = flag.String("gcflags", "", "Compiler flags for all revisions (passed to go test -gcflags)")
+	beforeBuild  = flag.String("beforebuild", "", "Extra go test build flags for the before branch")
+	afterBuild   = flag.String("afterbuild", "", "Extra go test build flags for the after branch")
+	revList      = flag.String("revs", "", `Compare these revisions ("a,b,c" or a range "a..b") instead of -before and -after`)
+	baseline     = flag.String("baseline", "", "Report deltas relative to this revision of -revs (default first)")
 )
 
+var commonEnv, beforeEnv, afterEnv envFlag
+
+func init() {
+	flag.Var(&commonEnv, "env", "Set KEY=VALUE in the environment of all builds and runs (repeatable)")
+	flag.Var(&beforeEnv, "beforeenv", "Set KEY=VALUE in the environment of the before branch (repeatable)")
+	flag.Var(&afterEnv, "afterenv", "Set KEY=VALUE in the environment of the after branch (repeatable)")
+}
+
 func main() {
 	flag.Parse()
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	var err error
+	if flag.NArg() != 0 && flag.Arg(0) == "bisect" {
+		err = runBisect(ctx, flag.Args()[1:])
+	} else {
+		err = run(ctx)
+	}
+	stop()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	curBranch, err := gitCurrentBranch(ctx)
+// checkSamples checks whether samples of n runs each can show a significant
+// difference. If not, it is an error when gated, since a regression check
+// would always pass; otherwise it warns that every difference is a wash.
+func checkSamples(n int, gated bool) error {
+	err := checkPower(n)
+	if err == nil {
+		return nil
+	} else if gated {
+		return fmt.Errorf("cannot check -fail-on-regression: %v", err)
+	}
+	log.Printf("Warning: every difference will be reported as ~, since %v", err)
+	return nil
+}
+
+func run(ctx context.Context) error {
+	thresh, err := parseThresholds(*failOn)
 	if err != nil {
-		log.Fatalf("Getting current branch: %v", err)
+		return fmt.Errorf("invalid -fail-on-regression: %v", err)
 	}
-	if *beforeBranch == "" {
-		*beforeBranch = "master"
+
+	var labels []string
+	var runs []*collector
+	var sess *session
+	switch flag.NArg() {
+	case 0:
+		if err := checkSamples(*runCount, len(thresh) != 0); err != nil {
+			return err
+		}
+		revs, err := listRevisions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range revs {
+			labels = append(labels, r.Label)
+		}
+		sess, err = newSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer sess.close()
+		runs, err = runRevisions(ctx, sess, revs)
+		if err != nil {
+			return err
+		}
+	case 1:
+		return errors.New("usage: benchdiff [options] [old.txt new.txt...]")
+	default:
+		if *saveDir != "" {
+			return errors.New("-save cannot be used when comparing saved results")
+		} else if *doProfile {
+			return errors.New("-profile cannot be used when comparing saved results")
+		} else if *revList != "" {
+			return errors.New("-revs cannot be used when comparing saved results")
+		}
+		for _, path := range flag.Args() {
+			c, err := loadFile(path)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, c)
+		}
+
+		// Check the smallest number of samples, ignoring runs with only one,
+		// since a single sample is not tested for significance.
+		n := 0
+		for _, c := range runs {
+			if m := c.maxSamples(); m > 1 && (n == 0 || m < n) {
+				n = m
+			}
+		}
+		if err := checkSamples(n, len(thresh) != 0); err != nil {
+			return err
+		}
+		labels = flag.Args()
+		if len(labels) == 2 {
+			labels = []string{"before", "after"}
+		}
 	}
-	if *afterBranch == "" {
-		*afterBranch = curBranch
+
+	base := 0
+	if *baseline != "" {
+		base = -1
+		for i, label := range labels {
+			if label == *baseline {
+				base = i
+				break
+			}
+		}
+		if base < 0 {
+			return fmt.Errorf("baseline %q is not among the revisions compared", *baseline)
+		}
 	}
-	if *beforeBranch == *afterBranch {
-		log.Fatalf("The -before and -after branches must be different (%q)", *beforeBranch)
+	rd := newReportData(labels, base, runs)
+	if err := report(os.Stdout, rd); err != nil {
+		return err
 	}
-	if *afterTest == "" {
-		*afterTest = *beforeTest
+	if *doProfile {
+		// Keep machine-readable output clean of the profile tables.
+		out := os.Stdout
+		if *outFormat != "text" {
+			out = os.Stderr
+		}
+		if err := profileRegressions(ctx, out, sess, rd); err != nil {
+			return fmt.Errorf("profiling: %v", err)
+		}
 	}
-
-	// Run the "before" benchmarks.
-	fmt.Fprintf(os.Stderr, "Running benchmarks in %q...\n", *beforeBranch)
-	if err := gitCheckout(ctx, *beforeBranch); err != nil {
-		log.Fatalf("Checking out %q: %v", *beforeBranch, err)
+	if regs := rd.regressions(thresh); len(regs) != 0 {
+		for _, c := range regs {
+			fmt.Fprintf(os.Stderr, "REGRESSION: %s %s [%s]: %s → %s (%.1f%%)\n",
+				c.Name, c.Unit, c.Rev, formatValue(c.Before), formatValue(c.After), c.Gain)
+		}
+		return fmt.Errorf("%d benchmark metrics regressed", len(regs))
 	}
-	start := time.Now()
-	before, err := runBenchmark(ctx, *beforeTest)
+	return nil
+}
+
+// loadFile reads saved benchmark output from the specified file.
+func loadFile(path string) (*collector, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Running -before benchmark: %v", err)
+		return nil, err
+	}
+	defer f.Close()
+	c := new(collector)
+	if err := c.parse(path, f); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
 	}
-	fmt.Fprintf(os.Stderr, "BEFORE [done] %d results, %v elapsed\n\n", len(before), time.Since(start))
+	fmt.Fprintf(os.Stderr, "Loaded %d results from %s\n", len(c.res), path)
+	return c, nil
+}
+
+// A revision is a version of the code to benchmark, and the configuration
+// with which to build and run it.
+type revision struct {
+	Label      string   // name for reports and saved output
+	Rev        string   // the git revision
+	Test       string   // the test package
+	BuildFlags []string // flags for "go test -c"
+	Env        []string // extra environment (KEY=VALUE) for building and running
+}
 
-	// Run the "after" benchmarks.
-	fmt.Fprintf(os.Stderr, "Running benchmarks in %q...\n", *afterBranch)
-	if err := gitCheckout(ctx, *afterBranch); err != nil {
-		log.Fatalf("Checking out %q: %v", *afterBranch, err)
+// newRevision returns a revision with the build flags and environment given
+// by the common flags, plus the extra build flags and environment given.
+func newRevision(label, rev, test, extraBuild string, extraEnv []string) (revision, error) {
+	r := revision{Label: label, Rev: rev, Test: test}
+	if *buildTags != "" {
+		r.BuildFlags = append(r.BuildFlags, "-tags="+*buildTags)
 	}
-	start = time.Now()
-	after, err := runBenchmark(ctx, *afterTest)
-	if err != nil {
-		log.Fatalf("Running -after benchmark: %v", err)
+	if *gcFlags != "" {
+		r.BuildFlags = append(r.BuildFlags, "-gcflags="+*gcFlags)
 	}
-	fmt.Fprintf(os.Stderr, "AFTER [done] %d results, %v elapsed\n\n", len(after), time.Since(start))
-
-	// Try to get back to where we started.
-	if err := gitCheckout(ctx, curBranch); err != nil {
-		log.Printf("Warning: unable to switch back to %q: %v", curBranch, err)
+	if extraBuild != "" {
+		args, ok := shell.Split(extraBuild)
+		if !ok {
+			return r, fmt.Errorf("invalid build flags for %s: %q", label, extraBuild)
+		}
+		r.BuildFlags = append(r.BuildFlags, args...)
 	}
+	r.Env = append(append(r.Env, commonEnv...), extraEnv...)
+	return r, nil
+}
 
-	// Summarize the results as a table to stdout.
-	hasMem := hasMemStats(before) || hasMemStats(after)
-	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+// sameConfig reports whether r and o are built and run the same way.
+func (r revision) sameConfig(o revision) bool {
+	return r.Test == o.Test &&
+		strings.Join(r.BuildFlags, "\x00") == strings.Join(o.BuildFlags, "\x00") &&
+		strings.Join(r.Env, "\x00") == strings.Join(o.Env, "\x00")
+}
 
-	fmt.Fprint(w, "BENCHMARK\tBEFORE\tAFTER\tSPEEDUP (%)")
-	if hasMem {
-		fmt.Fprint(w, "\tB/op (B)\tB/op (A)\tA/op (B)\tA/op (A)")
+// runFlags returns the flags to pass to each test binary.
+func runFlags() []string {
+	var args []string
+	if *benchTime != "" {
+		args = append(args, "-test.benchtime="+*benchTime)
+	}
+	if *cpuList != "" {
+		args = append(args, "-test.cpu="+*cpuList)
 	}
-	fmt.Fprintln(w)
-	for _, b := range joinResults(before, after) {
-		b.format(w, hasMem)
+	if *benchMem {
+		args = append(args, "-test.benchmem")
 	}
-	w.Flush()
+	return args
 }
 
-func gitCheckout(ctx context.Context, branch string) error {
-	return exec.CommandContext(ctx, "git", "checkout", branch).Run()
+// envFlag is a repeatable flag that accumulates KEY=VALUE settings.
+type envFlag []string
+
+func (e *envFlag) String() string { return strings.Join(*e, " ") }
+
+func (e *envFlag) Set(s string) error {
+	if i := strings.Index(s, "="); i <= 0 {
+		return fmt.Errorf("invalid setting %q (want KEY=VALUE)", s)
+	}
+	*e = append(*e, s)
+	return nil
 }
 
-func gitCurrentBranch(ctx context.Context) (string, error) {
-	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+// listRevisions returns the revisions selected by the flags. This is either
+// the -before and -after revisions, or those given by -revs.
+func listRevisions(ctx context.Context) ([]revision, error) {
+	if *revList != "" {
+		if *beforeBranch != "" || *afterBranch != "" {
+			return nil, errors.New("-revs cannot be combined with -before or -after")
+		}
+		var revs []revision
+		for _, arg := range strings.Split(*revList, ",") {
+			arg = strings.TrimSpace(arg)
+			if arg == "" {
+				continue
+			}
+			lo, hi, isRange := cut(arg, "..")
+			if !isRange {
+				r, err := newRevision(arg, arg, *beforeTest, "", nil)
+				if err != nil {
+					return nil, err
+				}
+				revs = append(revs, r)
+				continue
+			}
+			out, err := git(ctx, "", "rev-list", "--reverse", "--abbrev-commit", lo+".."+hi)
+			if err != nil {
+				return nil, fmt.Errorf("listing %q: %v", arg, err)
+			}
+			for _, c := range append([]string{lo}, strings.Fields(out)...) {
+				r, err := newRevision(c, c, *beforeTest, "", nil)
+				if err != nil {
+					return nil, err
+				}
+				revs = append(revs, r)
+			}
+		}
+		if len(revs) < 2 {
+			return nil, fmt.Errorf("-revs must select at least two revisions (got %d)", len(revs))
+		}
+		seen := make(map[string]bool)
+		for _, r := range revs {
+			if seen[r.Label] {
+				return nil, fmt.Errorf("revision %q is listed more than once", r.Label)
+			}
+			seen[r.Label] = true
+		}
+		return revs, nil
+	}
+
+	curBranch, err := git(ctx, "", "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("getting current branch: %v", err)
+	}
+	if *beforeBranch == "" {
+		*beforeBranch = "master"
+	}
+	if *afterBranch == "" {
+		*afterBranch = curBranch
+	}
+	if *afterTest == "" {
+		*afterTest = *beforeTest
+	}
+	before, err := newRevision("before", *beforeBranch, *beforeTest, *beforeBuild, beforeEnv)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	after, err := newRevision("after", *afterBranch, *afterTest, *afterBuild, afterEnv)
+	if err != nil {
+		return nil, err
+	}
+	if before.Rev == after.Rev && before.sameConfig(after) {
+		return nil, fmt.Errorf("the -before and -after branches must be different (%q), "+
+			"unless they are built or run differently", *beforeBranch)
 	}
-	return strings.TrimSpace(string(out)), nil
+	return []revision{before, after}, nil
 }
 
-type result struct {
-	Name        string
-	Count       int64
-	TimePerOp   time.Duration
-	BytesPerOp  int64 // may not be present
-	AllocsPerOp int64 // may not be present
-}
+// runRevisions builds and runs the benchmarks for the given revisions in
+// worktrees of sess, and returns the results for each. The worktrees remain
+// in sess afterward, in the same order as revs.
+func runRevisions(ctx context.Context, sess *session, revs []revision) ([]*collector, error) {
+	if *runCount < 1 {
+		return nil, fmt.Errorf("the -count must be positive (%d)", *runCount)
+	}
+	// Check out each revision in its own temporary worktree, so that the
+	// user's working tree is not disturbed.
+	for i, r := range revs {
+		fmt.Fprintf(os.Stderr, "Building benchmarks in %q...\n", r.Rev)
+		if _, err := sess.add(ctx, fmt.Sprintf("rev%d", i), r); err != nil {
+			return nil, fmt.Errorf("%s: %v", r.Label, err)
+		}
+	}
 
-func runBenchmark(ctx context.Context, test string) ([]result, error) {
-	out, err := exec.CommandContext(ctx, "go", "test", "-bench="+*benchPattern, "-run=^NONE", test).Output()
-	if err != nil {
-		if *ignoreErr {
-			log.Printf("Ignored error from test runner: %v", err)
-		} else {
+	// If requested, save the raw output of each run for later comparison.
+	outs := make([]io.Writer, len(revs))
+	if *saveDir != "" {
+		if err := os.MkdirAll(*saveDir, 0755); err != nil {
 			return nil, err
 		}
-	}
-	var res []result
-	for _, line := range strings.Split(string(out), "\n") {
-		if !strings.HasPrefix(line, "Benchmark") {
-			continue
-		}
-		fields := strings.Fields(line)
-		r := result{Name: fields[0], Count: parseInt(fields[1])}
-		for i := 2; i+1 < len(fields); i += 2 {
-			switch fields[i+1] {
-			case "ns/op":
-				r.TimePerOp = time.Duration(parseInt(fields[i])) / time.Nanosecond
-			case "B/op":
-				r.BytesPerOp = parseInt(fields[i])
-			case "allocs/op":
-				r.AllocsPerOp = parseInt(fields[i])
+		for i, r := range revs {
+			name := strings.ReplaceAll(r.Label, "/", "_") + ".txt"
+			f, err := os.Create(filepath.Join(*saveDir, name))
+			if err != nil {
+				return nil, err
 			}
+			defer f.Close()
+			outs[i] = f
 		}
-		res = append(res, r)
 	}
-	return res, nil
-}
 
-func hasMemStats(rs []result) bool {
-	for _, r := range rs {
-		if r.BytesPerOp > 0 && r.AllocsPerOp > 0 {
-			return true
+	// Run the benchmarks, interleaving the runs for each revision to reduce
+	// bias from thermal effects and other drift over time.
+	runs := make([]*collector, len(revs))
+	for i := range runs {
+		runs[i] = new(collector)
+	}
+	start := time.Now()
+	for i := 0; i < *runCount; i++ {
+		fmt.Fprintf(os.Stderr, "Running benchmarks [%d/%d]...\n", i+1, *runCount)
+		for j, w := range sess.trees {
+			if err := runBenchmark(ctx, w, runs[j], outs[j]); err != nil {
+				return nil, fmt.Errorf("running %s benchmark: %v", revs[j].Label, err)
+			}
 		}
 	}
-	return false
+	// Record how each revision was built and run, so that the report shows
+	// any differences.
+	for i, r := range revs {
+		if len(r.BuildFlags) != 0 {
+			runs[i].setConfig("buildflags", shell.Join(r.BuildFlags))
+		}
+		if len(r.Env) != 0 {
+			runs[i].setConfig("env", strings.Join(r.Env, " "))
+		}
+	}
+
+	var counts []string
+	for i, c := range runs {
+		counts = append(counts, fmt.Sprintf("%d %s", len(c.res), revs[i].Label))
+	}
+	fmt.Fprintf(os.Stderr, "[done] results: %s; %v elapsed\n\n",
+		strings.Join(counts, ", "), time.Since(start))
+	if *saveDir != "" {
+		fmt.Fprintf(os.Stderr, "Saved benchmark output to %s\n\n", *saveDir)
+	}
+	return runs, nil
 }
 
-func parseInt(s string) int64 {
-	v, _ := strconv.ParseInt(s, 10, 64)
-	return v
+// cut splits s around the first instance of sep, reporting whether sep was
+// found.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
 }
 
-type joined struct {
-	Name                 string
-	OldCount, NewCount   int64
-	OldTime, NewTime     time.Duration
-	OldBytes, NewBytes   int64
-	OldAllocs, NewAllocs int64
-}
-
-func (b joined) format(w io.Writer, mem bool) {
-	fmt.Fprintf(w, "%s\t%d\t%d\t", b.Name, b.OldTime, b.NewTime)
-	if b.OldTime == 0 {
-		// This benchmark did not exist in the original sample. Avert a zero
-		// division.
-		fmt.Fprint(w, "[new]")
-	} else if b.NewTime == 0 {
-		// This benchmark is missing from the comparison sample.
-		fmt.Fprint(w, "[gone]")
-	} else {
-		sp := 100 * float64(b.OldTime-b.NewTime) / float64(b.OldTime)
-		if math.Abs(sp) > *washLevel {
-			fmt.Fprintf(w, "%.1f", sp)
-		} else {
-			fmt.Fprint(w, "~")
+// runBenchmark runs each test binary built in w once, and adds the samples
+// they report to c. If save != nil, the raw output is also written to save.
+func runBenchmark(ctx context.Context, w *worktree, c *collector, save io.Writer) error {
+	args := append([]string{"-test.bench=" + *benchPattern, "-test.run=^NONE", "-test.count=1"}, runFlags()...)
+	for _, t := range w.Tests {
+		out, err := w.command(ctx, t.Dir, t.Path, args...).Output()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			} else if *ignoreErr {
+				log.Printf("Ignored error from test runner for %s: %v", t.Pkg, err)
+			} else {
+				return fmt.Errorf("%s: %v", t.Pkg, err)
+			}
+		}
+		if save != nil {
+			if _, err := save.Write(out); err != nil {
+				return fmt.Errorf("saving output: %v", err)
+			}
+		}
+
+		// The test binary normally reports its package, but don't rely on it.
+		c.setConfig("pkg", t.Pkg)
+		if err := c.parse(t.Pkg, bytes.NewReader(out)); err != nil {
+			return fmt.Errorf("%s: %v", t.Pkg, err)
 		}
 	}
-	if mem {
-		fmt.Fprintf(w, "\t%d\t%d\t%d\t%d", b.OldBytes, b.NewBytes, b.OldAllocs, b.NewAllocs)
-	}
-	fmt.Fprintln(w)
+	return nil
 }
 
-func joinResults(old, new []result) []joined {
+// A joined value gathers the results for a benchmark from each of several
+// runs. A result may be empty if the benchmark was not present in that run.
+type joined struct {
+	Pkg  string
+	Name string
+	Runs []result
+}
+
+// joinResults joins the results of each run by package and benchmark name.
+// The joined results are grouped by package, in order of first appearance.
+//
+// If the runs differ only in GOMAXPROCS, for example because each side sets a
+// different value in its environment, the -N suffix is ignored so that the
+// same benchmark is compared across them.
+func joinResults(runs ...[]result) []joined {
+	acrossProcs := differInProcs(runs...)
 	var res []joined
 	m := make(map[string]int)
-	for _, b := range old {
-		m[b.Name] = len(res)
-		res = append(res, joined{
-			Name:      b.Name,
-			OldCount:  b.Count,
-			OldTime:   b.TimePerOp,
-			OldBytes:  b.BytesPerOp,
-			OldAllocs: b.AllocsPerOp,
-		})
-	}
-	for _, b := range new {
-		if p, ok := m[b.Name]; ok {
-			res[p].NewCount = b.Count
-			res[p].NewTime = b.TimePerOp
-			res[p].NewBytes = b.BytesPerOp
-			res[p].NewAllocs = b.AllocsPerOp
-		} else {
-			res = append(res, joined{
-				Name:      b.Name,
-				NewCount:  b.Count,
-				NewTime:   b.TimePerOp,
-				NewBytes:  b.BytesPerOp,
-				NewAllocs: b.AllocsPerOp,
-			})
+	for i, rs := range runs {
+		for _, b := range rs {
+			name := b.Name
+			if acrossProcs {
+				name = b.baseName()
+			}
+			key := result{Pkg: b.Pkg, Name: name}.key()
+			p, ok := m[key]
+			if !ok {
+				p = len(res)
+				m[key] = p
+				res = append(res, joined{Pkg: b.Pkg, Name: name, Runs: make([]result, len(runs))})
+			}
+			res[p].Runs[i] = b
 		}
 	}
+
+	pkgs := make(map[string]int)
+	for _, j := range res {
+		if _, ok := pkgs[j.Pkg]; !ok {
+			pkgs[j.Pkg] = len(pkgs)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return pkgs[res[i].Pkg] < pkgs[res[j].Pkg] })
 	return res
 }
+
+// differInProcs reports whether the benchmarks of each run all have the same
+// GOMAXPROCS, but it is not the same for every run. Runs with no results are
+// ignored. If any run has several values, as with -cpu 1,4, the runs are
+// taken to differ in more than GOMAXPROCS.
+func differInProcs(runs ...[]result) bool {
+	var procs []int // one per run with results
+	for _, rs := range runs {
+		for i, b := range rs {
+			if i == 0 {
+				procs = append(procs, b.Procs)
+			} else if b.Procs != rs[0].Procs {
+				return false
+			}
+		}
+	}
+	for _, p := range procs {
+		if p != procs[0] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustCollect(t *testing.T, input string) *collector {
+	t.Helper()
+	c := new(collector)
+	if err := c.parse("test", strings.NewReader(input)); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	return c
+}
+
+func TestJoinResults(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          []string // joined names, each present in both runs
+	}{
+		{"same procs",
+			"BenchmarkA-8 1 5 ns/op\nBenchmarkB-8 1 6 ns/op\n",
+			"BenchmarkA-8 1 4 ns/op\nBenchmarkB-8 1 7 ns/op\n",
+			[]string{"BenchmarkA-8", "BenchmarkB-8"}},
+		{"different procs",
+			"BenchmarkA-2 1 5 ns/op\nBenchmarkB/n=1-2 1 6 ns/op\n",
+			"BenchmarkA-4 1 4 ns/op\nBenchmarkB/n=1-4 1 7 ns/op\n",
+			[]string{"BenchmarkA", "BenchmarkB/n=1"}},
+		{"GOMAXPROCS=1 has no suffix",
+			"BenchmarkA 1 5 ns/op\n",
+			"BenchmarkA-4 1 4 ns/op\n",
+			[]string{"BenchmarkA"}},
+		{"same -cpu list",
+			"BenchmarkA 1 5 ns/op\nBenchmarkA-4 1 2 ns/op\n",
+			"BenchmarkA 1 4 ns/op\nBenchmarkA-4 1 1 ns/op\n",
+			[]string{"BenchmarkA", "BenchmarkA-4"}},
+	}
+	for _, test := range tests {
+		b, a := mustCollect(t, test.before), mustCollect(t, test.after)
+		var got []string
+		for _, j := range joinResults(b.res, a.res) {
+			if len(j.Runs[0].Samples) == 0 || len(j.Runs[1].Samples) == 0 {
+				t.Errorf("%s: %q is missing from a run", test.name, j.Name)
+			}
+			got = append(got, j.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// Different -cpu lists are not matched across procs.
+	b := mustCollect(t, "BenchmarkA 1 5 ns/op\nBenchmarkA-2 1 4 ns/op\n")
+	a := mustCollect(t, "BenchmarkA-4 1 3 ns/op\nBenchmarkA-8 1 2 ns/op\n")
+	if got := len(joinResults(b.res, a.res)); got != 4 {
+		t.Errorf("different -cpu lists: got %d joined results, want 4", got)
+	}
+}
+
+func TestReportAcrossProcs(t *testing.T) {
+	b := mustCollect(t, "BenchmarkA-2 1 100 ns/op\n")
+	a := mustCollect(t, "BenchmarkA-4 1 50 ns/op\n")
+	rd := newReportData([]string{"before", "after"}, 0, []*collector{b, a})
+	if len(rd.Comparisons) != 1 {
+		t.Fatalf("got %d comparisons, want 1: %+v", len(rd.Comparisons), rd.Comparisons)
+	}
+	if c := rd.Comparisons[0]; c.Name != "BenchmarkA" || c.Status != "changed" || c.Gain != 50 {
+		t.Errorf("got %s %s %.1f%%, want BenchmarkA changed 50.0%%", c.Name, c.Status, c.Gain)
+	}
+}
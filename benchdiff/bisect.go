@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const bisectUsage = `Usage: benchdiff [options] bisect -good REV [-bad REV] [-bench RE] [-threshold T]
+
+Search the first-parent history between the -good and -bad revisions for the
+first commit at which the selected benchmarks regress, compared to -good, by
+more than the threshold. The threshold has the same form as the value of
+-fail-on-regression; a bare percentage applies to ns/op.
+
+At each step, the candidate commit and the -good revision are benchmarked
+-count times, interleaved. If -count > 1, only significant differences count;
+note that with fewer than 4 samples each, no difference is significant at the
+default -alpha level.
+
+Options:
+`
+
+// runBisect implements the "bisect" subcommand.
+func runBisect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bisect", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, bisectUsage)
+		fs.PrintDefaults()
+	}
+	good := fs.String("good", "", "A revision without the regression (required)")
+	bad := fs.String("bad", "HEAD", "A revision with the regression")
+	threshold := fs.String("threshold", "10%", "Regression threshold")
+	fs.StringVar(benchPattern, "bench", *benchPattern, "Run benchmarks matching this regexp")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 0 {
+		return fmt.Errorf("extra arguments after bisect: %q", fs.Args())
+	} else if *good == "" {
+		return errors.New("you must specify a -good revision")
+	} else if *runCount < 1 {
+		return fmt.Errorf("the -count must be positive (%d)", *runCount)
+	}
+	thresh, err := parseThresholds(*threshold)
+	if err != nil {
+		return fmt.Errorf("invalid -threshold: %v", err)
+	} else if v, ok := thresh[""]; ok {
+		delete(thresh, "")
+		if _, ok := thresh["ns/op"]; !ok {
+			thresh["ns/op"] = v
+		}
+	}
+	if err := checkPower(*runCount); err != nil {
+		return err
+	}
+
+	out, err := git(ctx, "", "rev-list", "--reverse", "--first-parent", "--abbrev-commit", *good+".."+*bad)
+	if err != nil {
+		return fmt.Errorf("listing commits: %v", err)
+	}
+	commits := strings.Fields(out)
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits between %q and %q", *good, *bad)
+	}
+	sess, err := newSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	// The baseline is built once, and is rerun alongside each candidate to
+	// reduce bias from drift over time. Each candidate is checked out in turn
+	// in a second worktree.
+	fmt.Fprintf(os.Stderr, "Building benchmarks in %q...\n", *good)
+	goodRev, err := newRevision("good", *good, *beforeTest, "", nil)
+	if err != nil {
+		return err
+	}
+	base, err := sess.add(ctx, "good", goodRev)
+	if err != nil {
+		return err
+	}
+	cand, err := newWorktree(ctx, sess.tmp, "test", commits[len(commits)-1])
+	if err != nil {
+		return fmt.Errorf("checking out %q: %v", *bad, err)
+	}
+	sess.trees = append(sess.trees, cand)
+
+	// classify reports the regressions of rev relative to the baseline.
+	classify := func(rev string) ([]comparison, error) {
+		if err := cand.checkout(ctx, rev); err != nil {
+			return nil, fmt.Errorf("checking out %q: %v", rev, err)
+		}
+		r := goodRev
+		r.Label, r.Rev = rev, rev
+		if err := cand.build(ctx, sess.prefix, r); err != nil {
+			return nil, fmt.Errorf("building %q: %v", rev, err)
+		}
+		baseRuns, candRuns := new(collector), new(collector)
+		for i := 0; i < *runCount; i++ {
+			if err := runBenchmark(ctx, base, baseRuns, nil); err != nil {
+				return nil, fmt.Errorf("running good benchmark: %v", err)
+			} else if err := runBenchmark(ctx, cand, candRuns, nil); err != nil {
+				return nil, fmt.Errorf("running %q benchmark: %v", rev, err)
+			}
+		}
+		rd := newReportData([]string{*good, rev}, 0, []*collector{baseRuns, candRuns})
+		return rd.regressions(thresh), nil
+	}
+
+	// Check that the bad revision is really bad, then search for the first bad
+	// commit. The invariant is that commits[lo] is good (or lo < 0, denoting
+	// the -good revision), and commits[hi] is bad.
+	lo, hi := -1, len(commits)-1
+	fmt.Fprintf(os.Stderr, "Checking %q (%d commits in range)...\n", *bad, len(commits))
+	regs, err := classify(commits[hi])
+	if err != nil {
+		return err
+	} else if len(regs) == 0 {
+		return fmt.Errorf("no regression found at %q", *bad)
+	}
+	for step := 1; hi-lo > 1; step++ {
+		mid := (lo + hi) / 2
+		fmt.Fprintf(os.Stderr, "Step %d: testing %s (%d untested in range)...\n", step, commits[mid], hi-lo-1)
+		r, err := classify(commits[mid])
+		if err != nil {
+			return err
+		}
+		if len(r) != 0 {
+			fmt.Fprintf(os.Stderr, "- %s is bad\n", commits[mid])
+			hi, regs = mid, r
+		} else {
+			fmt.Fprintf(os.Stderr, "- %s is good\n", commits[mid])
+			lo = mid
+		}
+	}
+
+	desc, err := git(ctx, "", "log", "-1", "--format=%h %s", commits[hi])
+	if err != nil {
+		desc = commits[hi]
+	}
+	fmt.Printf("First bad commit: %s\n", desc)
+	for _, c := range regs {
+		fmt.Printf("  %s %s: %s → %s (%.1f%%)\n",
+			c.Name, c.Unit, formatValue(c.Before), formatValue(c.After), c.Gain)
+	}
+	return nil
+}
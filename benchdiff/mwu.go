@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// median returns the median of vs, or 0 if vs is empty.
+func median(vs []float64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), vs...)
+	sort.Float64s(s)
+	if n := len(s); n%2 == 1 {
+		return s[n/2]
+	} else {
+		return (s[n/2-1] + s[n/2]) / 2
+	}
+}
+
+// spread returns the largest deviation of any value in vs from their median,
+// as a fraction of the median. It returns 0 if the median is 0.
+func spread(vs []float64) float64 {
+	m := median(vs)
+	if m == 0 {
+		return 0
+	}
+	var max float64
+	for _, v := range vs {
+		if d := math.Abs(v-m) / m; d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// mannWhitneyU reports the two-sided p-value of a Mann-Whitney U test of the
+// null hypothesis that the samples x and y are drawn from the same
+// distribution. If either sample is empty, it returns 1.
+//
+// When there are no ties and the samples are small, the exact distribution of
+// U is used; otherwise the p-value is computed using a normal approximation
+// with tie and continuity corrections.
+func mannWhitneyU(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	// Rank the combined samples, assigning tied values their mean rank.
+	type obs struct {
+		v     float64
+		fromX bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range y {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	var rankX, tieSum float64
+	hasTies := false
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // mean of 1-based ranks i+1..j
+		for k := i; k < j; k++ {
+			if all[k].fromX {
+				rankX += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			hasTies = true
+			tieSum += t*t*t - t
+		}
+		i = j
+	}
+	u := rankX - float64(n1*(n1+1))/2
+
+	if !hasTies && n1 <= 50 && n2 <= 50 {
+		return exactUPValue(int(u), n1, n2)
+	}
+
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - tieSum/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		return 1
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// exactUPValue returns the two-sided p-value for the statistic u of a
+// Mann-Whitney U test with sample sizes n1 and n2 and no ties.
+func exactUPValue(u, n1, n2 int) float64 {
+	// dist[m][k] is the number of arrangements of m values from the first
+	// sample among the values of the second giving U = k, built up one value
+	// of the second sample at a time.
+	maxU := n1 * n2
+	prev := make([][]float64, n1+1)
+	for m := range prev {
+		prev[m] = make([]float64, maxU+1)
+	}
+	for m := 0; m <= n1; m++ {
+		prev[m][0] = 1 // no values from the second sample
+	}
+	for j := 1; j <= n2; j++ {
+		cur := make([][]float64, n1+1)
+		cur[0] = make([]float64, maxU+1)
+		cur[0][0] = 1
+		for m := 1; m <= n1; m++ {
+			cur[m] = make([]float64, maxU+1)
+			for k := 0; k <= m*j; k++ {
+				// Either the largest value is from the first sample (which
+				// then exceeds all j values of the second), or it is not.
+				v := prev[m][k]
+				if k >= j {
+					v += cur[m-1][k-j]
+				}
+				cur[m][k] = v
+			}
+		}
+		prev = cur
+	}
+
+	dist := prev[n1]
+	var total, lo, hi float64
+	for k, c := range dist {
+		total += c
+		if k <= u {
+			lo += c
+		}
+		if k >= u {
+			hi += c
+		}
+	}
+	p := 2 * math.Min(lo, hi) / total
+	if p > 1 {
+		p = 1
+	}
+	return p
+}
+
+// minPValue returns the smallest two-sided p-value that a Mann-Whitney U test
+// can give for samples of sizes n1 and n2, which occurs when they do not
+// overlap at all.
+func minPValue(n1, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	return exactUPValue(0, n1, n2)
+}
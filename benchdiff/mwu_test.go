@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	seq := func(n int, start float64) []float64 {
+		vs := make([]float64, n)
+		for i := range vs {
+			vs[i] = start + float64(i)
+		}
+		return vs
+	}
+	tests := []struct {
+		name string
+		x, y []float64
+		want float64
+	}{
+		{"empty", nil, []float64{1, 2}, 1},
+		{"n=1", []float64{1}, []float64{2}, 1},
+		{"1+5 separated", []float64{1}, []float64{2, 3, 4, 5, 6}, 0.33333},
+		{"2+2 separated", []float64{1, 2}, []float64{3, 4}, 0.33333},
+		{"3+3 separated", []float64{1, 2, 3}, []float64{4, 5, 6}, 0.1},
+		{"5+5 separated", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0.00794},
+		{"5+5 reversed", []float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 0.00794},
+		{"4+4 overlapping", []float64{1, 2, 3, 5}, []float64{4, 6, 7, 8}, 0.05714},
+		{"interleaved", []float64{1, 3, 5}, []float64{2, 4, 6, 7}, 0.4},
+		{"all equal", []float64{3, 3, 3}, []float64{3, 3, 3}, 1},
+		{"one tie", []float64{1, 2, 3, 4, 5}, []float64{3, 6, 7, 8, 9}, 0.04653},
+		{"large", seq(51, 0), seq(51, 0.5), 0.86712},
+	}
+	for _, test := range tests {
+		got := mannWhitneyU(test.x, test.y)
+		if math.Abs(got-test.want) > 1e-5 {
+			t.Errorf("%s: mannWhitneyU(%v, %v) = %.5f, want %.5f", test.name, test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestExactUPValue(t *testing.T) {
+	tests := []struct {
+		u, n1, n2 int
+		want      float64
+	}{
+		{0, 1, 1, 1},
+		{1, 1, 1, 1},
+		{0, 5, 5, 0.00794},
+		{25, 5, 5, 0.00794},
+		{3, 4, 4, 0.2},
+		{1, 2, 3, 0.4},
+		{6, 3, 4, 1}, // the mean of U
+	}
+	for _, test := range tests {
+		got := exactUPValue(test.u, test.n1, test.n2)
+		if math.Abs(got-test.want) > 1e-5 {
+			t.Errorf("exactUPValue(%d, %d, %d) = %.5f, want %.5f", test.u, test.n1, test.n2, got, test.want)
+		}
+	}
+}
+
+func TestMinPValue(t *testing.T) {
+	tests := []struct {
+		n1, n2 int
+		want   float64
+	}{
+		{0, 5, 1},
+		{1, 1, 1},
+		{2, 2, 0.33333},
+		{3, 3, 0.1},
+		{4, 4, 0.02857},
+		{5, 5, 0.00794},
+		{2, 8, 0.04444},
+	}
+	for _, test := range tests {
+		if got := minPValue(test.n1, test.n2); math.Abs(got-test.want) > 1e-5 {
+			t.Errorf("minPValue(%d, %d) = %.5f, want %.5f", test.n1, test.n2, got, test.want)
+		}
+	}
+}
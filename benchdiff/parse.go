@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A result records the samples collected for a single benchmark.
+type result struct {
+	Pkg     string // package, from the "pkg" configuration (if any)
+	Name    string // full name, including any -N suffix
+	Procs   int    // GOMAXPROCS, from the -N suffix (0 if absent)
+	Samples []sample
+}
+
+// A sample is the outcome of one run of a benchmark.
+type sample struct {
+	Iters   int64
+	Metrics map[string]float64 // unit → value, e.g., "ns/op" → 103.5
+	Units   []string           // the units of Metrics, in order of appearance
+}
+
+// key returns a string that uniquely identifies the benchmark of r.
+func (r result) key() string { return r.Pkg + "\x00" + r.Name }
+
+// baseName returns the name of r without its -N suffix, if any.
+func (r result) baseName() string {
+	if r.Procs == 0 {
+		return r.Name
+	}
+	return strings.TrimSuffix(r.Name, "-"+strconv.Itoa(r.Procs))
+}
+
+// values returns the values reported for unit by each sample of r that
+// includes it.
+func (r result) values(unit string) []float64 {
+	var vs []float64
+	for _, s := range r.Samples {
+		if v, ok := s.Metrics[unit]; ok {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// A collector accumulates samples into results by package and benchmark name,
+// in order of first appearance, and records the units reported.
+type collector struct {
+	res    []result
+	pos    map[string]int
+	units  []string
+	config map[string]string // the current configuration
+}
+
+func (c *collector) add(name string, s sample) {
+	if c.pos == nil {
+		c.pos = make(map[string]int)
+	}
+	pkg := c.config["pkg"]
+	key := result{Pkg: pkg, Name: name}.key()
+	p, ok := c.pos[key]
+	if !ok {
+		p = len(c.res)
+		c.pos[key] = p
+		c.res = append(c.res, result{Pkg: pkg, Name: name, Procs: procsSuffix(name)})
+	}
+	c.res[p].Samples = append(c.res[p].Samples, s)
+	for _, unit := range s.Units {
+		if !c.hasUnit(unit) {
+			c.units = append(c.units, unit)
+		}
+	}
+}
+
+func (c *collector) hasUnit(unit string) bool {
+	for _, u := range c.units {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSamples returns the largest number of samples for any result in c.
+func (c *collector) maxSamples() int {
+	var max int
+	for _, r := range c.res {
+		if len(r.Samples) > max {
+			max = len(r.Samples)
+		}
+	}
+	return max
+}
+
+// setConfig sets a configuration value for c, which is reported with the run.
+func (c *collector) setConfig(key, val string) {
+	if c.config == nil {
+		c.config = make(map[string]string)
+	}
+	c.config[key] = val
+}
+
+// parse reads benchmark output in the Go benchmark data format from r and
+// adds the samples it contains to c. Configuration lines ("key: value")
+// update the configuration attributed to subsequent benchmarks; other lines
+// that are not benchmark results are ignored. Malformed result lines are
+// skipped with a warning that cites their line number in the named input.
+func (c *collector) parse(name string, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for ln := 1; sc.Scan(); ln++ {
+		line := sc.Text()
+		if key, val, ok := parseConfig(line); ok {
+			c.setConfig(key, val)
+			continue
+		}
+		bench, s, ok, err := parseBenchmark(line)
+		if err != nil {
+			log.Printf("Warning: %s:%d: skipped malformed result: %v", name, ln, err)
+		} else if ok {
+			c.add(bench, s)
+		}
+	}
+	return sc.Err()
+}
+
+// parseConfig reports whether line is a configuration line, and if so returns
+// its key and value. A configuration line has a key beginning with a
+// lower-case letter and containing no spaces, followed by a colon and either
+// a space or the end of the line.
+func parseConfig(line string) (key, val string, ok bool) {
+	i := strings.Index(line, ":")
+	if i <= 0 || strings.ContainsAny(line[:i], " \t") {
+		return "", "", false
+	}
+	if r, _ := utf8.DecodeRuneInString(line); !unicode.IsLower(r) {
+		return "", "", false
+	}
+	rest := line[i+1:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", "", false
+	}
+	return line[:i], strings.TrimSpace(rest), true
+}
+
+// parseBenchmark reports whether line is a benchmark result line, and if so
+// returns its name and sample. A result line has a name beginning with
+// "Benchmark" (not followed by a lower-case letter), an iteration count, and
+// zero or more value-unit pairs.
+func parseBenchmark(line string) (string, sample, bool, error) {
+	if !strings.HasPrefix(line, "Benchmark") {
+		return "", sample{}, false, nil
+	}
+	if r, _ := utf8.DecodeRuneInString(line[len("Benchmark"):]); unicode.IsLower(r) {
+		return "", sample{}, false, nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", sample{}, false, nil
+	}
+	iters, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		// This is probably not a result line, e.g., a log message.
+		return "", sample{}, false, nil
+	}
+	if len(fields)%2 != 0 {
+		return "", sample{}, false, fmt.Errorf("unpaired value in %q", line)
+	}
+	s := sample{Iters: iters, Metrics: make(map[string]float64)}
+	for i := 2; i+1 < len(fields); i += 2 {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return "", sample{}, false, fmt.Errorf("invalid value for %s: %v", fields[i+1], err)
+		}
+		if _, ok := s.Metrics[fields[i+1]]; !ok {
+			s.Units = append(s.Units, fields[i+1])
+		}
+		s.Metrics[fields[i+1]] = v
+	}
+	return fields[0], s, true, nil
+}
+
+// procsSuffix returns the value of the -N suffix of a benchmark name that
+// indicates the value of GOMAXPROCS, or 0 if there is none.
+func procsSuffix(name string) int {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+func copyConfig(m map[string]string) map[string]string {
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
+
+// higherIsBetter reports whether larger values of unit indicate better
+// performance. This is true for rates such as MB/s, and false for costs such
+// as ns/op, B/op, and allocs/op.
+func higherIsBetter(unit string) bool { return strings.HasSuffix(unit, "/s") }
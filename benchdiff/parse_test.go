@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBenchmark(t *testing.T) {
+	tests := []struct {
+		line  string
+		name  string
+		iters int64
+		units []string
+		vals  []float64
+		ok    bool
+	}{
+		{"", "", 0, nil, nil, false},
+		{"PASS", "", 0, nil, nil, false},
+		{"Benchmarking is fun", "", 0, nil, nil, false},
+		{"Benchmarks: 5", "", 0, nil, nil, false},
+		{"BenchmarkX", "", 0, nil, nil, false},
+		{"BenchmarkX log message", "", 0, nil, nil, false},
+		{"BenchmarkX-8 100", "BenchmarkX-8", 100, nil, nil, true},
+		{"BenchmarkX-8 \t 1000\t 103.5 ns/op", "BenchmarkX-8", 1000, []string{"ns/op"}, []float64{103.5}, true},
+		{"BenchmarkY/sub=1-4 50 2000 ns/op 64 B/op 1 allocs/op 12.5 MB/s",
+			"BenchmarkY/sub=1-4", 50,
+			[]string{"ns/op", "B/op", "allocs/op", "MB/s"},
+			[]float64{2000, 64, 1, 12.5}, true},
+		{"BenchmarkZ 10 9 z/op 1 a/op", "BenchmarkZ", 10, []string{"z/op", "a/op"}, []float64{9, 1}, true},
+	}
+	for _, test := range tests {
+		name, s, ok, err := parseBenchmark(test.line)
+		if err != nil {
+			t.Errorf("parseBenchmark(%q): unexpected error: %v", test.line, err)
+			continue
+		}
+		if ok != test.ok || name != test.name {
+			t.Errorf("parseBenchmark(%q): got %q, %v; want %q, %v", test.line, name, ok, test.name, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if s.Iters != test.iters {
+			t.Errorf("parseBenchmark(%q): got %d iterations, want %d", test.line, s.Iters, test.iters)
+		}
+		if !reflect.DeepEqual(s.Units, test.units) {
+			t.Errorf("parseBenchmark(%q): got units %q, want %q", test.line, s.Units, test.units)
+		}
+		for i, unit := range test.units {
+			if got := s.Metrics[unit]; got != test.vals[i] {
+				t.Errorf("parseBenchmark(%q): %s = %v, want %v", test.line, unit, got, test.vals[i])
+			}
+		}
+	}
+}
+
+func TestParseBenchmarkErrors(t *testing.T) {
+	for _, line := range []string{
+		"BenchmarkX 100 5",
+		"BenchmarkX 100 5 ns/op 6",
+		"BenchmarkX 100 fast ns/op",
+	} {
+		if name, _, _, err := parseBenchmark(line); err == nil {
+			t.Errorf("parseBenchmark(%q): got %q, want error", line, name)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		line     string
+		key, val string
+		ok       bool
+	}{
+		{"", "", "", false},
+		{"goos: linux", "goos", "linux", true},
+		{"pkg: github.com/creachadair/misctools/benchdiff", "pkg", "github.com/creachadair/misctools/benchdiff", true},
+		{"cpu: Intel(R) Xeon(R) CPU @ 2.20GHz", "cpu", "Intel(R) Xeon(R) CPU @ 2.20GHz", true},
+		{"note:", "note", "", true},
+		{"note:\t  padded  ", "note", "padded", true},
+		{"Goos: linux", "", "", false},     // key must begin with lower case
+		{"my key: value", "", "", false},   // key may not contain spaces
+		{"url:http://x", "", "", false},    // colon must be followed by space
+		{": value", "", "", false},         // key may not be empty
+		{"no colon here", "", "", false},   // not a configuration line
+		{"ok  \tpkg\t0.1s", "", "", false}, // test summary
+	}
+	for _, test := range tests {
+		key, val, ok := parseConfig(test.line)
+		if key != test.key || val != test.val || ok != test.ok {
+			t.Errorf("parseConfig(%q): got %q, %q, %v; want %q, %q, %v",
+				test.line, key, val, ok, test.key, test.val, test.ok)
+		}
+	}
+}
+
+func TestProcsSuffix(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"BenchmarkX", 0},
+		{"BenchmarkX-8", 8},
+		{"BenchmarkX/n=5-16", 16},
+		{"BenchmarkX/a-b", 0},
+		{"BenchmarkX-", 0},
+		{"BenchmarkX-0", 0},
+	}
+	for _, test := range tests {
+		if got := procsSuffix(test.name); got != test.want {
+			t.Errorf("procsSuffix(%q) = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	const input = `goos: linux
+pkg: a
+BenchmarkX-4   10   5 ns/op   2 B/op
+BenchmarkY-4   10   7 ns/op
+BenchmarkX-4   10   6 ns/op   3 B/op   1 allocs/op
+pkg: b
+BenchmarkX-4   10   8 MB/s
+PASS
+`
+	var c collector
+	if err := c.parse("test", strings.NewReader(input)); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if want := []string{"ns/op", "B/op", "allocs/op", "MB/s"}; !reflect.DeepEqual(c.units, want) {
+		t.Errorf("units: got %q, want %q", c.units, want)
+	}
+	var got []string
+	for _, r := range c.res {
+		got = append(got, r.Pkg+"."+r.Name)
+	}
+	if want := []string{"a.BenchmarkX-4", "a.BenchmarkY-4", "b.BenchmarkX-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("results: got %q, want %q", got, want)
+	}
+	if got, want := c.res[0].values("ns/op"), []float64{5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values: got %v, want %v", got, want)
+	}
+	if got := c.maxSamples(); got != 2 {
+		t.Errorf("maxSamples: got %d, want 2", got)
+	}
+	if got := c.config["pkg"]; got != "b" {
+		t.Errorf("config pkg: got %q, want %q", got, "b")
+	}
+}
+
+func TestCollectorSkipsMalformed(t *testing.T) {
+	var logBuf strings.Builder
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	const input = `BenchmarkX-8 100 1000 ns/op
+BenchmarkY-8 100 1000 ns/op 3
+BenchmarkX-8 100 fast ns/op
+BenchmarkX-8 100 1100 ns/op
+`
+	var c collector
+	if err := c.parse("old.txt", strings.NewReader(input)); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(c.res) != 1 || len(c.res[0].Samples) != 2 {
+		t.Errorf("got results %+v, want 2 samples of BenchmarkX-8", c.res)
+	}
+	for _, want := range []string{"old.txt:2: ", "old.txt:3: "} {
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("log output %q does not mention %q", logBuf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// A profile is the subset of a pprof profile needed to attribute the values
+// of one sample type to functions.
+type profile struct {
+	Total float64            // total value over all samples
+	Flat  map[string]float64 // function name → value in that function
+	Cum   map[string]float64 // function name → value in that function or its callees
+}
+
+// readProfile reads the pprof profile in the specified file, and attributes
+// the values of the first sample type whose name is in types (or the last
+// sample type, if none match) to functions. Only samples taken within a
+// benchmark are counted, if there are any.
+func readProfile(path string, types ...string) (*profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pp, err := parseProfileProto(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return pp.attribute(types...), nil
+}
+
+// rawProfile holds the decoded fields of a profile.proto message.
+type rawProfile struct {
+	sampleTypes []int64 // string table indexes of sample type names
+	samples     []rawSample
+	locFuncs    map[uint64][]uint64 // location ID → function IDs, innermost first
+	funcNames   map[uint64]int64    // function ID → string table index
+	strings     []string
+}
+
+type rawSample struct {
+	locs   []uint64
+	values []int64
+}
+
+func (pp *rawProfile) str(i int64) string {
+	if i < 0 || int(i) >= len(pp.strings) {
+		return ""
+	}
+	return pp.strings[i]
+}
+
+func (pp *rawProfile) attribute(types ...string) *profile {
+	idx := len(pp.sampleTypes) - 1
+search:
+	for i, st := range pp.sampleTypes {
+		for _, t := range types {
+			if pp.str(st) == t {
+				idx = i
+				break search
+			}
+		}
+	}
+	p := &profile{Flat: make(map[string]float64), Cum: make(map[string]float64)}
+	if idx < 0 {
+		return p
+	}
+
+	// Attribute only samples taken while running the benchmark, so that the
+	// work of the test framework and the profiler does not dilute the result.
+	// If there are none, use everything.
+	samples := pp.samples
+	var inBench []rawSample
+	for _, s := range samples {
+		if pp.hasFunc(s, benchFrame) {
+			inBench = append(inBench, s)
+		}
+	}
+	if len(inBench) != 0 {
+		samples = inBench
+	}
+
+	for _, s := range samples {
+		if idx >= len(s.values) {
+			continue
+		}
+		v := float64(s.values[idx])
+		p.Total += v
+		seen := make(map[string]bool)
+		for i, loc := range s.locs {
+			for j, fid := range pp.locFuncs[loc] {
+				name := pp.str(pp.funcNames[fid])
+				if i == 0 && j == 0 {
+					p.Flat[name] += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					p.Cum[name] += v
+				}
+			}
+		}
+	}
+	return p
+}
+
+// benchFrame is the function of the testing package that calls the body of a
+// benchmark.
+const benchFrame = "testing.(*B).runN"
+
+// hasFunc reports whether the stack of s includes the named function.
+func (pp *rawProfile) hasFunc(s rawSample, name string) bool {
+	for _, loc := range s.locs {
+		for _, fid := range pp.locFuncs[loc] {
+			if pp.str(pp.funcNames[fid]) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseProfileProto decodes the fields of a profile.proto message that are
+// needed by attribute.
+func parseProfileProto(data []byte) (*rawProfile, error) {
+	pp := &rawProfile{
+		locFuncs:  make(map[uint64][]uint64),
+		funcNames: make(map[uint64]int64),
+	}
+	err := forEachField(data, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1: // sample_type
+			return forEachField(b, func(num int, v uint64, _ []byte) error {
+				if num == 1 {
+					pp.sampleTypes = append(pp.sampleTypes, int64(v))
+				}
+				return nil
+			})
+		case 2: // sample
+			var s rawSample
+			err := forEachField(b, func(num int, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					return appendPacked(&s.locs, v, b)
+				case 2:
+					var vs []uint64
+					if err := appendPacked(&vs, v, b); err != nil {
+						return err
+					}
+					for _, x := range vs {
+						s.values = append(s.values, int64(x))
+					}
+				}
+				return nil
+			})
+			pp.samples = append(pp.samples, s)
+			return err
+		case 4: // location
+			var id uint64
+			var funcs []uint64
+			err := forEachField(b, func(num int, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					id = v
+				case 4: // line
+					return forEachField(b, func(num int, v uint64, _ []byte) error {
+						if num == 1 {
+							funcs = append(funcs, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			pp.locFuncs[id] = funcs
+			return err
+		case 5: // function
+			var id uint64
+			var name int64
+			err := forEachField(b, func(num int, v uint64, _ []byte) error {
+				switch num {
+				case 1:
+					id = v
+				case 2:
+					name = int64(v)
+				}
+				return nil
+			})
+			pp.funcNames[id] = name
+			return err
+		case 6: // string_table
+			pp.strings = append(pp.strings, string(b))
+		}
+		return nil
+	})
+	return pp, err
+}
+
+// forEachField calls f for each field of the protobuf message in data, with
+// the field number and either the value of a varint field or the contents of
+// a length-delimited field. Fixed-width fields are skipped.
+func forEachField(data []byte, f func(num int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		num, wire := int(key>>3), key&7
+		switch wire {
+		case 0: // varint
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errors.New("invalid varint")
+			}
+			data = data[n:]
+			if err := f(num, v, nil); err != nil {
+				return err
+			}
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return errors.New("truncated field")
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errors.New("invalid length")
+			}
+			b := data[n : n+int(size)]
+			data = data[n+int(size):]
+			if err := f(num, 0, b); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return errors.New("truncated field")
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+	}
+	return nil
+}
+
+// appendPacked appends to vs a repeated varint field, given either its
+// single value v or its packed encoding b.
+func appendPacked(vs *[]uint64, v uint64, b []byte) error {
+	if b == nil {
+		*vs = append(*vs, v)
+		return nil
+	}
+	for len(b) > 0 {
+		x, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid packed varint")
+		}
+		*vs = append(*vs, x)
+		b = b[n:]
+	}
+	return nil
+}
+
+// A funcDelta records the change in the share of a profile attributed to a
+// function.
+type funcDelta struct {
+	Name                string
+	OldFlat, NewFlat    float64 // percent of total
+	OldCum, NewCum      float64 // percent of total
+	DeltaFlat, DeltaCum float64 // percentage points
+}
+
+// diffProfiles returns the functions whose share of old and new changed the
+// most, up to n of them, ordered by decreasing magnitude of change.
+func diffProfiles(old, new *profile, n int) []funcDelta {
+	share := func(m map[string]float64, p *profile, name string) float64 {
+		if p.Total == 0 {
+			return 0
+		}
+		return 100 * m[name] / p.Total
+	}
+	names := make(map[string]bool)
+	for _, p := range []*profile{old, new} {
+		for name := range p.Cum {
+			names[name] = true
+		}
+	}
+	var out []funcDelta
+	for name := range names {
+		d := funcDelta{
+			Name:    name,
+			OldFlat: share(old.Flat, old, name),
+			NewFlat: share(new.Flat, new, name),
+			OldCum:  share(old.Cum, old, name),
+			NewCum:  share(new.Cum, new, name),
+		}
+		d.DeltaFlat = d.NewFlat - d.OldFlat
+		d.DeltaCum = d.NewCum - d.OldCum
+		out = append(out, d)
+	}
+	mag := func(d funcDelta) float64 { return math.Max(math.Abs(d.DeltaFlat), math.Abs(d.DeltaCum)) }
+	sort.Slice(out, func(i, j int) bool {
+		if mi, mj := mag(out[i]), mag(out[j]); mi != mj {
+			return mi > mj
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// writeProfileDiff writes a table of function deltas to w.
+func writeProfileDiff(w io.Writer, title string, ds []funcDelta) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintf(tw, "%s\tFLAT%% (B)\tFLAT%% (A)\tΔFLAT\tCUM%% (B)\tCUM%% (A)\tΔCUM\n", title)
+	for _, d := range ds {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%+.1f\t%.1f\t%.1f\t%+.1f\n",
+			d.Name, d.OldFlat, d.NewFlat, d.DeltaFlat, d.OldCum, d.NewCum, d.DeltaCum)
+	}
+	return tw.Flush()
+}
+
+// profileRegressions reruns each benchmark that regressed in rd with CPU and
+// memory profiling, on the baseline and the regressed revision, and writes
+// the functions whose share of each profile changed most to w. The worktrees
+// of sess correspond to the labels of rd.
+func profileRegressions(ctx context.Context, w io.Writer, sess *session, rd *reportData) error {
+	index := make(map[string]int)
+	for i, label := range rd.Labels {
+		index[label] = i
+	}
+	// The test binaries run in their package directories, so the profile
+	// paths must be absolute.
+	dir := sess.tmp
+	if *saveDir != "" {
+		abs, err := filepath.Abs(*saveDir)
+		if err != nil {
+			return err
+		}
+		dir = abs
+	}
+
+	done := make(map[string]bool)
+	for _, c := range rd.Comparisons {
+		key := c.Pkg + "\x00" + c.Name + "\x00" + c.Rev
+		if c.Summary || !c.Significant() || c.Gain >= 0 || done[key] {
+			continue
+		}
+		done[key] = true
+		fmt.Fprintf(os.Stderr, "Profiling %s in %s and %s...\n", c.Name, c.Base, c.Rev)
+
+		var profs [2][2]*profile // [revision][cpu, mem]
+		for i, label := range []string{c.Base, c.Rev} {
+			base := fileName(label) + "." + fileName(c.Pkg) + "." + fileName(c.Name)
+			cpu := filepath.Join(dir, base+".cpu.pprof")
+			mem := filepath.Join(dir, base+".mem.pprof")
+			wt := sess.trees[index[label]]
+			t, ok := wt.testFor(c.Pkg)
+			if !ok {
+				return fmt.Errorf("no test binary for %q in %s", c.Pkg, label)
+			}
+			// Profile CPU and memory in separate runs, since recording every
+			// allocation (so that the memory profile is not dominated by the
+			// allocations of the profiler itself) skews the CPU profile.
+			for _, pflags := range [][]string{
+				{"-test.cpuprofile=" + cpu},
+				{"-test.memprofile=" + mem, "-test.memprofilerate=1"},
+			} {
+				args := append([]string{"-test.run=^NONE", "-test.bench=" + benchRegexp(c.Name), "-test.count=1"}, pflags...)
+				args = append(args, runFlags()...)
+				if out, err := wt.command(ctx, t.Dir, t.Path, args...).CombinedOutput(); err != nil {
+					return fmt.Errorf("running %s in %s: %v\n%s", c.Name, label, err, out)
+				}
+			}
+			var err error
+			if profs[i][0], err = readProfile(cpu, "cpu"); err != nil {
+				return err
+			} else if profs[i][1], err = readProfile(mem, "alloc_space"); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(w, "\n%s (%s → %s)\n", c.Name, c.Base, c.Rev)
+		for j, title := range []string{"CPU", "ALLOCATED BYTES"} {
+			if j > 0 {
+				fmt.Fprintln(w)
+			}
+			ds := diffProfiles(profs[0][j], profs[1][j], *profileTop)
+			if err := writeProfileDiff(w, title, ds); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// benchRegexp returns a -test.bench pattern that matches exactly the named
+// benchmark, which may include a GOMAXPROCS suffix and sub-benchmarks.
+func benchRegexp(name string) string {
+	if procsSuffix(name) > 0 {
+		name = name[:strings.LastIndex(name, "-")]
+	}
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = "^" + regexp.QuoteMeta(p) + "$"
+	}
+	return strings.Join(parts, "/")
+}
+
+// fileName returns s with characters that are awkward in file names replaced.
+func fileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// Helpers to encode protobuf fields.
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func pbVarint(num int, v uint64) []byte {
+	b := appendUvarint(nil, uint64(num)<<3)
+	return appendUvarint(b, v)
+}
+
+func pbBytes(num int, data ...[]byte) []byte {
+	var body []byte
+	for _, d := range data {
+		body = append(body, d...)
+	}
+	b := appendUvarint(nil, uint64(num)<<3|2)
+	b = appendUvarint(b, uint64(len(body)))
+	return append(b, body...)
+}
+
+func pbPacked(num int, vs ...uint64) []byte {
+	var body []byte
+	for _, v := range vs {
+		body = appendUvarint(body, v)
+	}
+	return pbBytes(num, body)
+}
+
+func pbFixed64(num int) []byte {
+	b := appendUvarint(nil, uint64(num)<<3|1)
+	return append(b, make([]byte, 8)...)
+}
+
+func TestForEachField(t *testing.T) {
+	var data []byte
+	data = append(data, pbVarint(1, 300)...)
+	data = append(data, pbFixed64(2)...)
+	data = append(data, pbBytes(3, []byte("hello"))...)
+	data = append(data, 0x25, 1, 2, 3, 4) // field 4, 32-bit
+	data = append(data, pbVarint(5, 0)...)
+
+	type field struct {
+		Num int
+		V   uint64
+		B   string
+	}
+	var got []field
+	if err := forEachField(data, func(num int, v uint64, b []byte) error {
+		got = append(got, field{num, v, string(b)})
+		return nil
+	}); err != nil {
+		t.Fatalf("forEachField: unexpected error: %v", err)
+	}
+	want := []field{{1, 300, ""}, {3, 0, "hello"}, {5, 0, ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("forEachField: got %+v, want %+v", got, want)
+	}
+}
+
+func TestForEachFieldErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated key", []byte{0x80}},
+		{"truncated varint", []byte{0x08, 0x80}},
+		{"truncated 64-bit", []byte{0x09, 1, 2, 3}},
+		{"truncated 32-bit", []byte{0x0d, 1, 2}},
+		{"truncated bytes", []byte{0x0a, 5, 'a', 'b'}},
+		{"group", []byte{0x0b}},
+	}
+	nop := func(int, uint64, []byte) error { return nil }
+	for _, test := range tests {
+		if err := forEachField(test.data, nop); err == nil {
+			t.Errorf("forEachField(%s): got nil, want error", test.name)
+		}
+	}
+}
+
+func TestParseProfileProto(t *testing.T) {
+	strs := []string{"", "samples", "count", "cpu", "nanoseconds",
+		"main.f", "main.g", benchFrame, "runtime.gc"}
+
+	var data []byte
+	add := func(b []byte) { data = append(data, b...) }
+	add(pbBytes(1, pbVarint(1, 1), pbVarint(2, 2))) // samples/count
+	add(pbBytes(1, pbVarint(1, 3), pbVarint(2, 4))) // cpu/nanoseconds
+
+	// Packed and unpacked encodings of repeated fields are both accepted.
+	add(pbBytes(2, pbPacked(1, 1, 2, 3), pbPacked(2, 1, 10)))                        // f ← g ← runN
+	add(pbBytes(2, pbVarint(1, 2), pbVarint(1, 3), pbVarint(2, 2), pbVarint(2, 20))) // g ← runN
+	add(pbBytes(2, pbPacked(1, 4), pbPacked(2, 5, 50)))                              // gc, outside the benchmark
+	add(pbFixed64(9))                                                                // time_nanos, ignored
+	for id := uint64(1); id <= 4; id++ {
+		add(pbBytes(4, pbVarint(1, id), pbBytes(4, pbVarint(1, id), pbVarint(2, 7)))) // location → line → function
+		add(pbBytes(5, pbVarint(1, id), pbVarint(2, id+4)))                           // function → name
+	}
+	for _, s := range strs {
+		add(pbBytes(6, []byte(s)))
+	}
+
+	pp, err := parseProfileProto(data)
+	if err != nil {
+		t.Fatalf("parseProfileProto: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(pp.strings, strs) {
+		t.Errorf("strings: got %q, want %q", pp.strings, strs)
+	}
+	if want := []int64{1, 3}; !reflect.DeepEqual(pp.sampleTypes, want) {
+		t.Errorf("sample types: got %v, want %v", pp.sampleTypes, want)
+	}
+	wantSamples := []rawSample{
+		{locs: []uint64{1, 2, 3}, values: []int64{1, 10}},
+		{locs: []uint64{2, 3}, values: []int64{2, 20}},
+		{locs: []uint64{4}, values: []int64{5, 50}},
+	}
+	if !reflect.DeepEqual(pp.samples, wantSamples) {
+		t.Errorf("samples: got %+v, want %+v", pp.samples, wantSamples)
+	}
+
+	tests := []struct {
+		types []string
+		want  *profile
+	}{
+		{[]string{"cpu"}, &profile{
+			Total: 30,
+			Flat:  map[string]float64{"main.f": 10, "main.g": 20},
+			Cum:   map[string]float64{"main.f": 10, "main.g": 30, benchFrame: 30},
+		}},
+		{[]string{"alloc_space", "samples"}, &profile{
+			Total: 3,
+			Flat:  map[string]float64{"main.f": 1, "main.g": 2},
+			Cum:   map[string]float64{"main.f": 1, "main.g": 3, benchFrame: 3},
+		}},
+		{nil, &profile{ // the last sample type
+			Total: 30,
+			Flat:  map[string]float64{"main.f": 10, "main.g": 20},
+			Cum:   map[string]float64{"main.f": 10, "main.g": 30, benchFrame: 30},
+		}},
+	}
+	for _, test := range tests {
+		if got := pp.attribute(test.types...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("attribute(%q): got %+v, want %+v", test.types, got, test.want)
+		}
+	}
+}
+
+func TestAttributeOutsideBenchmark(t *testing.T) {
+	// If no samples were taken within the benchmark, all are counted.
+	pp := &rawProfile{
+		sampleTypes: []int64{1},
+		samples:     []rawSample{{locs: []uint64{1}, values: []int64{4}}},
+		locFuncs:    map[uint64][]uint64{1: {1}},
+		funcNames:   map[uint64]int64{1: 2},
+		strings:     []string{"", "cpu", "main.init"},
+	}
+	want := &profile{
+		Total: 4,
+		Flat:  map[string]float64{"main.init": 4},
+		Cum:   map[string]float64{"main.init": 4},
+	}
+	if got := pp.attribute("cpu"); !reflect.DeepEqual(got, want) {
+		t.Errorf("attribute: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A comparison summarizes the difference in one metric of a benchmark
+// between a baseline (before) and another (after) set of results.
+type comparison struct {
+	Pkg    string  `json:"pkg,omitempty"`
+	Name   string  `json:"name"`
+	Unit   string  `json:"unit"`
+	Base   string  `json:"base"` // label of the baseline
+	Rev    string  `json:"rev"`  // label of the compared revision
+	Before summary `json:"before"`
+	After  summary `json:"after"`
+
+	// Status is one of "new" (no before values), "gone" (no after values),
+	// "~" (no significant difference), or "changed".
+	Status string  `json:"status"`
+	Gain   float64 `json:"gain"`        // percent improvement; positive is better
+	P      float64 `json:"p,omitempty"` // p-value, if there are multiple samples
+
+	// Summary is true for a comparison of the geometric means of the medians
+	// of all the benchmarks in a package. For a summary, N is the number of
+	// benchmarks included.
+	Summary bool `json:"summary,omitempty"`
+}
+
+// A summary describes the samples of one metric for one side of a
+// comparison.
+type summary struct {
+	Median float64 `json:"median"`
+	Spread float64 `json:"spread"` // fraction of the median
+	N      int     `json:"n"`
+}
+
+// compare compares the values of unit for runs i (before) and j (after) of
+// b. It returns false if neither run reported any values for unit. If multi is
+// true, differences that are not significant at the level given by -alpha are
+// treated as a wash.
+func (b joined) compare(i, j int, unit string, multi bool) (comparison, bool) {
+	oldV, newV := b.Runs[i].values(unit), b.Runs[j].values(unit)
+	if len(oldV) == 0 && len(newV) == 0 {
+		return comparison{}, false
+	}
+	c := comparison{
+		Pkg:    b.Pkg,
+		Name:   b.Name,
+		Unit:   unit,
+		Before: summary{Median: median(oldV), Spread: spread(oldV), N: len(oldV)},
+		After:  summary{Median: median(newV), Spread: spread(newV), N: len(newV)},
+		Status: "changed",
+	}
+	if len(oldV) == 0 {
+		// This benchmark did not exist in the original sample.
+		c.Status = "new"
+		return c, true
+	} else if len(newV) == 0 {
+		// This benchmark is missing from the comparison sample.
+		c.Status = "gone"
+		return c, true
+	} else if c.Before.Median == 0 {
+		// Avert a zero division.
+		if c.After.Median == 0 {
+			c.Status = "~"
+		}
+		return c, true
+	}
+	c.Gain = 100 * (c.Before.Median - c.After.Median) / c.Before.Median
+	if higherIsBetter(unit) {
+		c.Gain = -c.Gain
+	}
+	if multi {
+		c.P = mannWhitneyU(oldV, newV)
+	}
+	if math.Abs(c.Gain) <= *washLevel || (multi && c.P >= *alpha) {
+		c.Status = "~"
+	}
+	return c, true
+}
+
+// Significant reports whether c represents a significant change in a metric
+// that was present before and after.
+func (c comparison) Significant() bool {
+	return c.Status == "changed" && c.Before.Median != 0
+}
+
+func (c comparison) gainString() string {
+	switch {
+	case c.Status == "new" || c.Status == "gone":
+		return "[" + c.Status + "]"
+	case c.Status != "changed":
+		return c.Status
+	case c.Before.Median == 0:
+		return "[was 0]"
+	default:
+		return strconv.FormatFloat(c.Gain, 'f', 1, 64)
+	}
+}
+
+func (c comparison) pString() string {
+	if c.Summary || c.Before.N == 0 || c.After.N == 0 {
+		return ""
+	}
+	return fmt.Sprintf("p=%.3f n=%d+%d", c.P, c.Before.N, c.After.N)
+}
+
+// values returns the formatted before and after values of c.
+func (c comparison) values() (before, after string) {
+	if c.Summary {
+		// The N of a summary does not count samples, so omit the spread.
+		return formatValue(summary{Median: c.Before.Median, N: 1}),
+			formatValue(summary{Median: c.After.Median, N: 1})
+	}
+	return formatValue(c.Before), formatValue(c.After)
+}
+
+// formatValue formats a summary, including the spread if there is more than
+// one sample.
+func formatValue(s summary) string {
+	var v string
+	if s.N == 0 {
+		return "-"
+	} else if s.Median == math.Trunc(s.Median) || math.Abs(s.Median) >= 1000 {
+		v = strconv.FormatFloat(s.Median, 'f', 0, 64)
+	} else {
+		v = strconv.FormatFloat(s.Median, 'g', 4, 64)
+	}
+	if s.N > 1 {
+		v += fmt.Sprintf(" ±%.0f%%", 100*s.Spread)
+	}
+	return v
+}
+
+// A reportData value gathers everything needed to render a report.
+type reportData struct {
+	Labels      []string            // one per run
+	Packages    []string            // in order of presentation
+	Base        int                 // index of the baseline run
+	Configs     []map[string]string // configuration, one per run
+	Units       []string            // in order of presentation
+	Multi       bool                // whether any result has multiple samples
+	Comparisons []comparison        // grouped by unit, then package, then benchmark
+}
+
+// newReportData compares the results of each run to those of the baseline
+// run, base. The labels name the runs.
+func newReportData(labels []string, base int, runs []*collector) *reportData {
+	rd := &reportData{Labels: labels, Base: base}
+	var units [][]string
+	var results [][]result
+	for _, c := range runs {
+		rd.Configs = append(rd.Configs, c.config)
+		units = append(units, c.units)
+		results = append(results, c.res)
+		if c.maxSamples() > 1 {
+			rd.Multi = true
+		}
+	}
+	rd.Units = unionUnits(units...)
+
+	joined := joinResults(results...)
+	for i, b := range joined {
+		if i == 0 || b.Pkg != joined[i-1].Pkg {
+			rd.Packages = append(rd.Packages, b.Pkg)
+		}
+	}
+	if len(rd.Packages) > 1 {
+		// The package is reported with each group, so remove it from the
+		// configuration, where it reflects only the last package seen.
+		for i, c := range rd.Configs {
+			cp := copyConfig(c)
+			delete(cp, "pkg")
+			rd.Configs[i] = cp
+		}
+	}
+
+	for _, unit := range rd.Units {
+		for lo := 0; lo < len(joined); {
+			hi := lo + 1
+			for hi < len(joined) && joined[hi].Pkg == joined[lo].Pkg {
+				hi++
+			}
+			for i := range runs {
+				if i == base {
+					continue
+				}
+				var group []comparison
+				for _, b := range joined[lo:hi] {
+					if c, ok := b.compare(base, i, unit, rd.Multi); ok {
+						c.Base, c.Rev = labels[base], labels[i]
+						group = append(group, c)
+					}
+				}
+				if gm, ok := geomean(group); ok {
+					gm.Base, gm.Rev = labels[base], labels[i]
+					group = append(group, gm)
+				}
+				rd.Comparisons = append(rd.Comparisons, group...)
+			}
+			lo = hi
+		}
+	}
+	return rd
+}
+
+// geomean returns a summary comparison of the geometric means of the medians
+// of the comparisons in cs, which must all be for the same package and unit.
+// Only benchmarks with nonzero values before and after are included. It
+// returns false if there are fewer than two of those.
+func geomean(cs []comparison) (comparison, bool) {
+	var n int
+	var logB, logA float64
+	for _, c := range cs {
+		if c.Before.N == 0 || c.After.N == 0 || c.Before.Median <= 0 || c.After.Median <= 0 {
+			continue
+		}
+		n++
+		logB += math.Log(c.Before.Median)
+		logA += math.Log(c.After.Median)
+	}
+	if n < 2 {
+		return comparison{}, false
+	}
+	gm := comparison{
+		Pkg:     cs[0].Pkg,
+		Name:    "[geomean]",
+		Unit:    cs[0].Unit,
+		Before:  summary{Median: math.Exp(logB / float64(n)), N: n},
+		After:   summary{Median: math.Exp(logA / float64(n)), N: n},
+		Status:  "changed",
+		Summary: true,
+	}
+	gm.Gain = 100 * (gm.Before.Median - gm.After.Median) / gm.Before.Median
+	if higherIsBetter(gm.Unit) {
+		gm.Gain = -gm.Gain
+	}
+	if math.Abs(gm.Gain) <= *washLevel {
+		gm.Status = "~"
+	}
+	return gm, true
+}
+
+// multiPackage reports whether rd includes results from multiple packages.
+func (rd *reportData) multiPackage() bool { return len(rd.Packages) > 1 }
+
+// isMatrix reports whether rd should be rendered as a matrix, rather than as
+// a two-way comparison.
+func (rd *reportData) isMatrix() bool { return len(rd.Labels) != 2 || rd.Base != 0 }
+
+// byUnit returns the comparisons for the specified unit.
+func (rd *reportData) byUnit(unit string) []comparison {
+	var out []comparison
+	for _, c := range rd.Comparisons {
+		if c.Unit == unit {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// A matrixRow gives the comparisons for one benchmark, indexed by run. The
+// entry for the baseline run, and for any run that did not report the
+// metric, are nil.
+type matrixRow struct {
+	Pkg   string
+	Name  string
+	Base  summary
+	Cells []*comparison
+}
+
+// matrix returns the rows of the matrix for unit.
+func (rd *reportData) matrix(unit string) []matrixRow {
+	var rows []matrixRow
+	pos := make(map[string]int)
+	index := make(map[string]int)
+	for i, label := range rd.Labels {
+		index[label] = i
+	}
+	for _, c := range rd.byUnit(unit) {
+		c := c
+		key := c.Pkg + "\x00" + c.Name
+		p, ok := pos[key]
+		if !ok {
+			p = len(rows)
+			pos[key] = p
+			rows = append(rows, matrixRow{
+				Pkg:   c.Pkg,
+				Name:  c.Name,
+				Base:  c.Before,
+				Cells: make([]*comparison, len(rd.Labels)),
+			})
+		}
+		rows[p].Cells[index[c.Rev]] = &c
+	}
+	return rows
+}
+
+// cellString formats the value and delta for one cell of a matrix row.
+func (r matrixRow) cellString(i, base int) string {
+	var c *comparison
+	for _, cell := range r.Cells {
+		if cell != nil {
+			c = cell
+			break
+		}
+	}
+	if i == base {
+		before, _ := c.values()
+		return before
+	} else if c = r.Cells[i]; c == nil || c.After.N == 0 {
+		return "-"
+	}
+	_, after := c.values()
+	if c.Significant() {
+		return fmt.Sprintf("%s (%+.1f%%)", after, c.Gain)
+	}
+	return fmt.Sprintf("%s (%s)", after, c.gainString())
+}
+
+// report writes a summary of the before and after results to out in the
+// format selected by -o.
+func report(out io.Writer, rd *reportData) error {
+	switch *outFormat {
+	case "", "text":
+		return reportText(out, rd)
+	case "json":
+		return reportJSON(out, rd)
+	case "csv":
+		return reportCSV(out, rd)
+	case "markdown", "md":
+		return reportMarkdown(out, rd)
+	default:
+		return fmt.Errorf("unknown output format %q", *outFormat)
+	}
+}
+
+// reportText writes the report as a table, with a section for each unit.
+func reportText(out io.Writer, rd *reportData) error {
+	if rd.isMatrix() {
+		printConfig(out, rd.Labels, rd.Configs)
+	} else {
+		printConfig(out, []string{"B", "A"}, rd.Configs)
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	first := true
+	for _, unit := range rd.Units {
+		if rd.isMatrix() {
+			rows := rd.matrix(unit)
+			for lo := 0; lo < len(rows); {
+				hi := lo + 1
+				for hi < len(rows) && rows[hi].Pkg == rows[lo].Pkg {
+					hi++
+				}
+				rd.textHeader(w, &first, rows[lo].Pkg)
+				fmt.Fprintf(w, "BENCHMARK (%s)", unit)
+				for j, label := range rd.Labels {
+					if j == rd.Base {
+						label += " [base]"
+					}
+					fmt.Fprintf(w, "\t%s", label)
+				}
+				fmt.Fprintln(w)
+				for _, row := range rows[lo:hi] {
+					fmt.Fprint(w, row.Name)
+					for j := range rd.Labels {
+						fmt.Fprintf(w, "\t%s", row.cellString(j, rd.Base))
+					}
+					fmt.Fprintln(w)
+				}
+				lo = hi
+			}
+			continue
+		}
+		cs := rd.byUnit(unit)
+		for lo := 0; lo < len(cs); {
+			hi := lo + 1
+			for hi < len(cs) && cs[hi].Pkg == cs[lo].Pkg {
+				hi++
+			}
+			rd.textHeader(w, &first, cs[lo].Pkg)
+			fmt.Fprintf(w, "BENCHMARK\t%[1]s (B)\t%[1]s (A)\tGAIN (%%)", unit)
+			if rd.Multi {
+				fmt.Fprint(w, "\tP")
+			}
+			fmt.Fprintln(w)
+			for _, c := range cs[lo:hi] {
+				before, after := c.values()
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s", c.Name, before, after, c.gainString())
+				if rd.Multi {
+					fmt.Fprintf(w, "\t%s", c.pString())
+				}
+				fmt.Fprintln(w)
+			}
+			lo = hi
+		}
+	}
+	return w.Flush()
+}
+
+// textHeader writes the separator before a table of the text report, and
+// the name of its package if rd includes multiple packages.
+func (rd *reportData) textHeader(w io.Writer, first *bool, pkg string) {
+	if !*first {
+		fmt.Fprintln(w)
+	}
+	*first = false
+	if rd.multiPackage() {
+		fmt.Fprintf(w, "pkg: %s\n", pkg)
+	}
+}
+
+// reportMarkdown writes the report as Markdown tables, suitable for posting
+// as a comment on a pull request.
+func reportMarkdown(out io.Writer, rd *reportData) error {
+	for _, key := range configKeys(rd.Configs...) {
+		if v, ok := sameConfig(key, rd.Configs); ok {
+			fmt.Fprintf(out, "- %s: `%s`\n", key, v)
+			continue
+		}
+		var vs []string
+		for i, c := range rd.Configs {
+			vs = append(vs, fmt.Sprintf("`%s` (%s)", c[key], rd.Labels[i]))
+		}
+		fmt.Fprintf(out, "- %s: %s\n", key, strings.Join(vs, ", "))
+	}
+	for _, unit := range rd.Units {
+		if rd.isMatrix() {
+			fmt.Fprintf(out, "\n| Benchmark (%s) |", unit)
+			for j, label := range rd.Labels {
+				if j == rd.Base {
+					label += " (base)"
+				}
+				fmt.Fprintf(out, " %s |", label)
+			}
+			fmt.Fprintf(out, "\n|:--|%s\n", strings.Repeat("--:|", len(rd.Labels)))
+			rows := rd.matrix(unit)
+			for j, row := range rows {
+				if rd.multiPackage() && (j == 0 || row.Pkg != rows[j-1].Pkg) {
+					fmt.Fprintf(out, "| **%s** |%s\n", row.Pkg, strings.Repeat(" |", len(rd.Labels)))
+				}
+				fmt.Fprintf(out, "| `%s` |", row.Name)
+				for j := range rd.Labels {
+					fmt.Fprintf(out, " %s |", row.cellString(j, rd.Base))
+				}
+				fmt.Fprintln(out)
+			}
+			continue
+		}
+		fmt.Fprintf(out, "\n| Benchmark | %[1]s (before) | %[1]s (after) | Gain (%%) |", unit)
+		if rd.Multi {
+			fmt.Fprint(out, " P |")
+		}
+		fmt.Fprint(out, "\n|:--|--:|--:|--:|")
+		if rd.Multi {
+			fmt.Fprint(out, ":--|")
+		}
+		fmt.Fprintln(out)
+		cs := rd.byUnit(unit)
+		for j, c := range cs {
+			if rd.multiPackage() && (j == 0 || c.Pkg != cs[j-1].Pkg) {
+				fmt.Fprintf(out, "| **%s** | | | |", c.Pkg)
+				if rd.Multi {
+					fmt.Fprint(out, " |")
+				}
+				fmt.Fprintln(out)
+			}
+			gain := c.gainString()
+			if c.Significant() && c.Gain < 0 {
+				gain = "**" + gain + "**"
+			}
+			before, after := c.values()
+			fmt.Fprintf(out, "| `%s` | %s | %s | %s |", c.Name, before, after, gain)
+			if rd.Multi {
+				fmt.Fprintf(out, " %s |", c.pString())
+			}
+			fmt.Fprintln(out)
+		}
+	}
+	return nil
+}
+
+// reportCSV writes the report as comma-separated values, one row per
+// comparison.
+func reportCSV(out io.Writer, rd *reportData) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{
+		"pkg", "name", "unit", "base", "rev",
+		"before", "before_spread", "before_n",
+		"after", "after_spread", "after_n",
+		"status", "gain", "p",
+	})
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for _, c := range rd.Comparisons {
+		w.Write([]string{
+			c.Pkg, c.Name, c.Unit, c.Base, c.Rev,
+			ff(c.Before.Median), ff(c.Before.Spread), strconv.Itoa(c.Before.N),
+			ff(c.After.Median), ff(c.After.Spread), strconv.Itoa(c.After.N),
+			c.Status, ff(c.Gain), ff(c.P),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// reportJSON writes the report as a JSON object.
+func reportJSON(out io.Writer, rd *reportData) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Config  map[string]map[string]string `json:"config,omitempty"`
+		Results []comparison                 `json:"results"`
+	}{
+		Config:  configMap(rd.Labels, rd.Configs),
+		Results: rd.Comparisons,
+	})
+}
+
+// unionUnits returns the units in any of the given lists, in order of first
+// appearance, with ns/op first if present.
+func unionUnits(lists ...[]string) []string {
+	var all, out []string
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	seen := make(map[string]bool)
+	for _, u := range all {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		if u == "ns/op" {
+			out = append([]string{u}, out...)
+		} else {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// configKeys returns the sorted union of the keys of the given
+// configurations.
+func configKeys(cs ...map[string]string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, c := range cs {
+		for key := range c {
+			if !seen[key] {
+				keys = append(keys, key)
+				seen[key] = true
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameConfig reports whether key has the same value in all the given
+// configurations, and if so returns that value.
+func sameConfig(key string, cs []map[string]string) (string, bool) {
+	for _, c := range cs[1:] {
+		if c[key] != cs[0][key] {
+			return "", false
+		}
+	}
+	return cs[0][key], true
+}
+
+// configMap returns a map from label to configuration.
+func configMap(labels []string, cs []map[string]string) map[string]map[string]string {
+	m := make(map[string]map[string]string)
+	for i, c := range cs {
+		m[labels[i]] = c
+	}
+	return m
+}
+
+// printConfig writes the configurations reported by each run to w, noting
+// where they differ. The labels name the runs.
+func printConfig(w io.Writer, labels []string, cs []map[string]string) {
+	keys := configKeys(cs...)
+	if len(keys) == 0 {
+		return
+	}
+	for _, key := range keys {
+		if v, ok := sameConfig(key, cs); ok {
+			fmt.Fprintf(w, "%s: %s\n", key, v)
+			continue
+		}
+		var vs []string
+		for i, c := range cs {
+			vs = append(vs, fmt.Sprintf("%s (%s)", c[key], labels[i]))
+		}
+		fmt.Fprintf(w, "%s: %s\n", key, strings.Join(vs, " | "))
+	}
+	fmt.Fprintln(w)
+}
+
+// parseThresholds parses a comma-separated list of regression thresholds.
+// Each entry is either a percentage ("5%" or "5"), which applies to all
+// units, or a unit and a percentage separated by "=" ("ns/op=5%"), which
+// overrides the default for that unit. The default for all units is stored
+// under the empty string.
+func parseThresholds(s string) (map[string]float64, error) {
+	m := make(map[string]float64)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var unit, pct string
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			unit, pct = entry[:i], entry[i+1:]
+			if unit == "" {
+				return nil, fmt.Errorf("missing unit in %q", entry)
+			}
+		} else {
+			pct = entry
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(pct, "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %v", pct, err)
+		} else if v < 0 {
+			return nil, fmt.Errorf("threshold %q is negative", pct)
+		}
+		m[unit] = v
+	}
+	return m, nil
+}
+
+// checkPower reports an error if samples of n runs each cannot show a
+// significant difference at the level given by -alpha, so that a regression
+// check would always pass.
+func checkPower(n int) error {
+	if n <= 1 {
+		return nil // differences are not tested for significance
+	} else if *alpha <= 0 {
+		return fmt.Errorf("no difference can be significant at -alpha %g", *alpha)
+	}
+	if p := minPValue(n, n); p >= *alpha {
+		need := n + 1
+		for minPValue(need, need) >= *alpha {
+			need++
+		}
+		return fmt.Errorf("with -count %d no difference can be significant at -alpha %g (the smallest p-value is %.3g); use -count %d or more",
+			n, *alpha, p, need)
+	}
+	return nil
+}
+
+// regressions returns the comparisons in rd that got worse by more than the
+// threshold for their unit. If rd has multiple samples, only significant
+// differences are counted. Units without a threshold are not checked.
+func (rd *reportData) regressions(thresh map[string]float64) []comparison {
+	var out []comparison
+	for _, c := range rd.Comparisons {
+		limit, ok := thresh[c.Unit]
+		if !ok {
+			limit, ok = thresh[""]
+		}
+		if !ok || c.Summary || c.Before.N == 0 || c.After.N == 0 || c.Before.Median == 0 {
+			continue
+		} else if rd.Multi && c.P >= *alpha {
+			continue
+		}
+		if -c.Gain > limit {
+			out = append(out, c)
+		}
+	}
+	return out
+}
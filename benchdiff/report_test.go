@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseThresholds(t *testing.T) {
+	tests := []struct {
+		input string
+		want  map[string]float64
+	}{
+		{"", map[string]float64{}},
+		{"5%", map[string]float64{"": 5}},
+		{"2.5", map[string]float64{"": 2.5}},
+		{"ns/op=5%", map[string]float64{"ns/op": 5}},
+		{"10%, ns/op=5%, B/op=0", map[string]float64{"": 10, "ns/op": 5, "B/op": 0}},
+		{"ns/op=5%,ns/op=3%", map[string]float64{"ns/op": 3}}, // last wins
+		{"x=y=1", map[string]float64{"x=y": 1}},               // the unit may contain "="
+	}
+	for _, test := range tests {
+		got, err := parseThresholds(test.input)
+		if err != nil {
+			t.Errorf("parseThresholds(%q): unexpected error: %v", test.input, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseThresholds(%q): got %v, want %v", test.input, got, test.want)
+		}
+	}
+	for _, bad := range []string{"=5%", "fast", "ns/op=", "ns/op=x%", "-1%", "B/op=-2"} {
+		if got, err := parseThresholds(bad); err == nil {
+			t.Errorf("parseThresholds(%q): got %v, want error", bad, got)
+		}
+	}
+}
+
+func TestRegressions(t *testing.T) {
+	cmp := func(name, unit string, gain, p float64) comparison {
+		return comparison{
+			Name: name, Unit: unit, Gain: gain, P: p, Status: "changed",
+			Before: summary{Median: 100, N: 5},
+			After:  summary{Median: 100 - gain, N: 5},
+		}
+	}
+	zeroBase := cmp("Zero", "allocs/op", -100, 0.01)
+	zeroBase.Before.Median = 0
+	isNew := cmp("New", "ns/op", -50, 0)
+	isNew.Before = summary{}
+	isNew.Status = "new"
+	geo := cmp("[geomean]", "ns/op", -50, 0)
+	geo.Summary = true
+
+	all := []comparison{
+		cmp("Slow", "ns/op", -10, 0.01),
+		cmp("Noisy", "ns/op", -10, 0.2), // not significant
+		cmp("Close", "ns/op", -3, 0.01), // within the threshold
+		cmp("Fast", "ns/op", 20, 0.01),  // an improvement
+		cmp("Alloc", "B/op", -1, 0.01),
+		cmp("Rate", "MB/s", -8, 0.01),
+		zeroBase, isNew, geo,
+	}
+	tests := []struct {
+		name   string
+		thresh string
+		multi  bool
+		want   []string
+	}{
+		{"none", "", true, nil},
+		{"default", "5%", true, []string{"Slow", "Rate"}},
+		{"override", "5%,B/op=0", true, []string{"Slow", "Alloc", "Rate"}},
+		{"unit only", "ns/op=5%", true, []string{"Slow"}},
+		{"loose unit", "5%,ns/op=15%", true, []string{"Rate"}},
+		{"single samples", "5%", false, []string{"Slow", "Noisy", "Rate"}},
+	}
+	for _, test := range tests {
+		thresh, err := parseThresholds(test.thresh)
+		if err != nil {
+			t.Fatalf("parseThresholds(%q): %v", test.thresh, err)
+		}
+		rd := &reportData{Multi: test.multi, Comparisons: all}
+		var got []string
+		for _, c := range rd.regressions(thresh) {
+			got = append(got, c.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: regressions(%q) = %q, want %q", test.name, test.thresh, got, test.want)
+		}
+	}
+}
+
+func TestCheckPower(t *testing.T) {
+	for _, n := range []int{0, 1, 4, 10} {
+		if err := checkPower(n); err != nil {
+			t.Errorf("checkPower(%d): unexpected error: %v", n, err)
+		}
+	}
+	for _, n := range []int{2, 3} {
+		if err := checkPower(n); err == nil {
+			t.Errorf("checkPower(%d): got nil, want error", n)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A worktree is a temporary git worktree with a revision checked out, and
+// test binaries built from it.
+type worktree struct {
+	Rev   string       // the revision checked out
+	Dir   string       // the root of the worktree
+	Tests []testBinary // the compiled test binaries (set by build)
+	Env   []string     // extra environment for building and running (set by build)
+}
+
+// A testBinary is a compiled test binary for one package.
+type testBinary struct {
+	Pkg  string // the import path of the package
+	Path string // the path of the binary
+	Dir  string // the package directory, in which the binary must be run
+}
+
+// newWorktree creates a detached worktree for rev in a new directory under
+// tmp named by tag.
+func newWorktree(ctx context.Context, tmp, tag, rev string) (*worktree, error) {
+	dir := filepath.Join(tmp, tag)
+	if _, err := git(ctx, "", "worktree", "add", "--detach", dir, rev); err != nil {
+		return nil, err
+	}
+	return &worktree{Rev: rev, Dir: dir}, nil
+}
+
+// build compiles a test binary for each package with tests matched by the
+// package pattern r.Test, interpreted relative to prefix within the worktree,
+// using the build flags and environment of r.
+func (w *worktree) build(ctx context.Context, prefix string, r revision) error {
+	test := r.Test
+	if test == "" {
+		test = "."
+	}
+	w.Env, w.Tests = r.Env, nil
+	pkgDir := filepath.Join(w.Dir, prefix)
+
+	const format = "{{if or .TestGoFiles .XTestGoFiles}}{{.ImportPath}}\t{{.Dir}}{{end}}"
+	args := append([]string{"list", "-f", format}, r.BuildFlags...)
+	out, err := w.command(ctx, pkgDir, "go", append(args, test)...).Output()
+	if err != nil {
+		return fmt.Errorf("listing %q: %v", test, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		pkg, dir, ok := cut(line, "\t")
+		if !ok {
+			continue
+		}
+		bin := filepath.Join(w.Dir, fmt.Sprintf(".benchdiff.%d.test", len(w.Tests)))
+		args := append([]string{"test", "-c", "-o", bin}, r.BuildFlags...)
+		cmd := w.command(ctx, pkgDir, "go", append(args, pkg)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("building %q: %v\n%s", pkg, err, out)
+		} else if _, err := os.Stat(bin); err != nil {
+			return fmt.Errorf("no test binary for %q", pkg)
+		}
+		w.Tests = append(w.Tests, testBinary{Pkg: pkg, Path: bin, Dir: dir})
+	}
+	if len(w.Tests) == 0 {
+		return fmt.Errorf("no packages with tests match %q", test)
+	}
+	return nil
+}
+
+// command returns a command to run name with args in dir, with the
+// environment of w.
+func (w *worktree) command(ctx context.Context, dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	if len(w.Env) != 0 {
+		cmd.Env = append(os.Environ(), w.Env...)
+	}
+	return cmd
+}
+
+// testFor returns the test binary for pkg in w, if there is one.
+func (w *worktree) testFor(pkg string) (testBinary, bool) {
+	for _, t := range w.Tests {
+		if t.Pkg == pkg {
+			return t, true
+		}
+	}
+	return testBinary{}, false
+}
+
+// checkout switches the worktree to rev. The test binary must be rebuilt.
+func (w *worktree) checkout(ctx context.Context, rev string) error {
+	if _, err := git(ctx, w.Dir, "checkout", "--quiet", "--detach", rev); err != nil {
+		return err
+	}
+	w.Rev, w.Tests = rev, nil
+	return nil
+}
+
+// remove deletes the worktree and its directory.
+func (w *worktree) remove(ctx context.Context) error {
+	_, err := git(ctx, "", "worktree", "remove", "--force", w.Dir)
+	return err
+}
+
+// A session manages a temporary directory and the worktrees created in it.
+type session struct {
+	tmp    string // temporary directory
+	prefix string // path of the working directory relative to the repository root
+	trees  []*worktree
+}
+
+func newSession(ctx context.Context) (*session, error) {
+	prefix, err := git(ctx, "", "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("getting repository prefix: %v", err)
+	}
+	tmp, err := os.MkdirTemp("", "benchdiff")
+	if err != nil {
+		return nil, err
+	}
+	return &session{tmp: tmp, prefix: prefix}, nil
+}
+
+// add creates a worktree for r in s named by tag, and builds its test binary.
+func (s *session) add(ctx context.Context, tag string, r revision) (*worktree, error) {
+	w, err := newWorktree(ctx, s.tmp, tag, r.Rev)
+	if err != nil {
+		return nil, fmt.Errorf("checking out %q: %v", r.Rev, err)
+	}
+	s.trees = append(s.trees, w)
+	if err := w.build(ctx, s.prefix, r); err != nil {
+		return nil, fmt.Errorf("building benchmark: %v", err)
+	}
+	return w, nil
+}
+
+// close removes the worktrees of s and its temporary directory. It is safe
+// to call close after the context used to create s has ended.
+func (s *session) close() {
+	for _, w := range s.trees {
+		if err := w.remove(context.Background()); err != nil {
+			log.Printf("Warning: removing worktree for %q: %v", w.Rev, err)
+		}
+	}
+	os.RemoveAll(s.tmp)
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var ex *exec.ExitError
+		if errors.As(err, &ex) {
+			return "", errors.New(strings.SplitN(string(ex.Stderr), "\n", 2)[0])
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
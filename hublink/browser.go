@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var (
+	doCopy  bool         // copy output to the clipboard
+	copyBuf bytes.Buffer // output to be copied
+)
+
+// isWSL reports whether we are running under the Windows Subsystem for Linux.
+func isWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	return err == nil && bytes.Contains(bytes.ToLower(data), []byte("microsoft"))
+}
+
+// browserCommands returns candidate commands to open a URL, in order of
+// preference. An argument "%s" is replaced by the URL; if there is none, the
+// URL is appended.
+func browserCommands(cfg *config) [][]string {
+	var cands [][]string
+	if len(cfg.Browser) != 0 {
+		cands = append(cands, cfg.Browser)
+	}
+	// By convention, $BROWSER is a colon-separated list of commands.
+	for _, b := range strings.Split(os.Getenv("BROWSER"), ":") {
+		if words := strings.Fields(b); len(words) != 0 {
+			cands = append(cands, words)
+		}
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		cands = append(cands, []string{"open"})
+	case "windows":
+		cands = append(cands, []string{"rundll32", "url.dll,FileProtocolHandler"})
+	default:
+		if isWSL() {
+			cands = append(cands, []string{"wslview"})
+		}
+		// Not "open", which on Debian and its derivatives is openvt.
+		cands = append(cands, []string{"xdg-open"})
+	}
+	return cands
+}
+
+// clipboardCommands returns candidate commands that copy their standard
+// input to the clipboard, in order of preference.
+func clipboardCommands(cfg *config) [][]string {
+	var cands [][]string
+	if len(cfg.Clipboard) != 0 {
+		cands = append(cands, cfg.Clipboard)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		cands = append(cands, []string{"pbcopy"})
+	case "windows":
+		cands = append(cands, []string{"clip"})
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			cands = append(cands, []string{"wl-copy"})
+		}
+		if isWSL() {
+			cands = append(cands, []string{"clip.exe"})
+		}
+		cands = append(cands,
+			[]string{"xclip", "-selection", "clipboard"},
+			[]string{"xsel", "--clipboard", "--input"},
+		)
+	}
+	return cands
+}
+
+// findCommand returns the first of cands whose program is available.
+func findCommand(cands [][]string) []string {
+	for _, c := range cands {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// openURL opens s in a web browser. If no way to do that is found, it warns
+// but does not fail.
+func openURL(s string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cmd := findCommand(browserCommands(cfg))
+	if cmd == nil {
+		warnf("cannot open a browser; set $BROWSER or add a browser line to %s", configPath())
+		return nil
+	}
+	args := make([]string, 0, len(cmd))
+	subst := false
+	for _, arg := range cmd[1:] {
+		if strings.Contains(arg, "%s") {
+			arg, subst = strings.ReplaceAll(arg, "%s", s), true
+		}
+		args = append(args, arg)
+	}
+	if !subst {
+		args = append(args, s)
+	}
+	return exec.Command(cmd[0], args...).Run()
+}
+
+// copyOutput copies the output saved in copyBuf to the clipboard. If no way
+// to do that is found, it warns but does not fail.
+func copyOutput() error {
+	if copyBuf.Len() == 0 {
+		return nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cmd := findCommand(clipboardCommands(cfg))
+	if cmd == nil {
+		warnf("cannot copy to the clipboard; add a clipboard line to %s", configPath())
+		return nil
+	}
+	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Stdin = bytes.NewReader(bytes.TrimSuffix(copyBuf.Bytes(), []byte("\n")))
+	c.Stderr = os.Stderr
+	return c.Run()
+}
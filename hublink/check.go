@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/misctools/hublink/forgelink"
+)
+
+var (
+	doCheck     bool // check that links refer to pushed content
+	useLsRemote bool // with doCheck, query the remote rather than tracking refs
+)
+
+func warnf(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Warning: "+msg+"\n", args...)
+}
+
+// checkLink checks whether a link to lines lr of path at target (a branch
+// name or commit hash) will show what is in the working tree, where real is
+// the path relative to the repository root. It warns about any discrepancy,
+// and returns the target and lines to link to instead.
+//
+// If target is not present on the remote, the link falls back to the nearest
+// ancestor commit that is. If the linked lines differ from the working tree
+// but occur exactly once elsewhere in the linked version, the lines are
+// adjusted to match.
+func (r *forgeRepo) checkLink(target, path, real string, lr forgelink.Range) (string, forgelink.Range) {
+	local, err := git("rev-parse", "--verify", target+"^{commit}")
+	if err != nil {
+		warnf("cannot resolve %q: %v", target, err)
+		return target, lr
+	}
+	linked, ok := r.pushedCommit(target)
+	if !ok {
+		base := r.nearestPushed(local)
+		if base == "" {
+			warnf("%q is not on remote %q, and has no pushed ancestor", target, r.Local)
+			return target, lr
+		}
+		warnf("%q is not on remote %q; linking to pushed ancestor %.12s", target, r.Local, base)
+		target, linked = base, base
+	} else if linked != local {
+		warnf("%q differs between the local repository (%.12s) and remote %q (%.12s)",
+			target, local, r.Local, linked)
+	}
+	if path == "" || isDir(path) {
+		return target, lr
+	}
+	return target, checkLines(linked, path, real, lr)
+}
+
+// pushedCommit reports the commit hash that target refers to on the remote,
+// and whether it is present there at all.
+func (r *forgeRepo) pushedCommit(target string) (string, bool) {
+	if forgelink.IsHash(target) {
+		// A commit is present if it is reachable from some remote branch.
+		for _, tip := range r.remoteTips() {
+			if _, err := git("merge-base", "--is-ancestor", target, tip); err == nil {
+				return target, true
+			}
+		}
+		return "", false
+	}
+	if useLsRemote {
+		out, err := git("ls-remote", r.Local, "refs/heads/"+target, "refs/tags/"+target)
+		if err != nil || out == "" {
+			return "", false
+		}
+		return strings.Fields(out)[0], true
+	}
+	hash, err := git("rev-parse", "--verify", "--quiet", "refs/remotes/"+r.Local+"/"+target)
+	return hash, err == nil && hash != ""
+}
+
+// remoteTips returns the commit hashes of the branches of the remote that
+// exist in the local repository.
+func (r *forgeRepo) remoteTips() []string {
+	var out string
+	var err error
+	if useLsRemote {
+		out, err = git("ls-remote", "--heads", r.Local)
+	} else {
+		out, err = git("for-each-ref", "--format=%(objectname)", "refs/remotes/"+r.Local)
+	}
+	if err != nil {
+		return nil
+	}
+	var tips []string
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if _, err := git("cat-file", "-e", f[0]+"^{commit}"); err == nil {
+			tips = append(tips, f[0])
+		}
+	}
+	return tips
+}
+
+// nearestPushed returns the nearest ancestor of commit that is reachable from
+// a branch of the remote, or "" if there is none.
+func (r *forgeRepo) nearestPushed(commit string) string {
+	tips := r.remoteTips()
+	if len(tips) == 0 {
+		return ""
+	}
+	// With more than two arguments, merge-base finds the best common ancestor
+	// of the first and a hypothetical merge of the rest.
+	base, err := git("merge-base", append([]string{commit}, tips...)...)
+	if err != nil {
+		return ""
+	}
+	return base
+}
+
+// checkLines compares lines lr of path in the working tree (relative to the
+// repository root as real) to the same lines at commit, and returns the lines
+// to link to.
+func checkLines(commit, path, real string, lr forgelink.Range) forgelink.Range {
+	want, err := os.ReadFile(path)
+	if err != nil {
+		return lr
+	}
+	have, err := gitRaw("show", commit+":"+real)
+	if err != nil {
+		warnf("%s does not exist at %.12s", real, commit)
+		return lr
+	}
+	if lr.Lo <= 0 {
+		if !bytes.Equal(want, have) {
+			warnf("%s differs from the version at %.12s", real, commit)
+		}
+		return lr
+	}
+	lo, hi := lr.Lo, lr.Hi
+	if hi < lo {
+		hi = lo
+	}
+	wantLines, haveLines := splitLines(want), splitLines(have)
+	if hi > len(wantLines) {
+		return lr
+	}
+	block := wantLines[lo-1 : hi]
+	if hi <= len(haveLines) && equalLines(block, haveLines[lo-1:hi]) {
+		return lr
+	}
+
+	// Look for the lines elsewhere in the linked version.
+	var found []int
+	for i := 0; i+len(block) <= len(haveLines); i++ {
+		if equalLines(block, haveLines[i:i+len(block)]) {
+			found = append(found, i+1)
+		}
+	}
+	if len(found) == 1 {
+		nlo := found[0]
+		warnf("%s:%s is at %s in %.12s", real, span(lo, hi), span(nlo, nlo+hi-lo), commit)
+		lr.Lo, lr.Hi = nlo, nlo+hi-lo
+		return lr
+	}
+	warnf("%s:%s differs from the version at %.12s", real, span(lo, hi), commit)
+	return lr
+}
+
+// span formats a line or range of lines.
+func span(lo, hi int) string {
+	if hi > lo {
+		return fmt.Sprintf("%d-%d", lo, hi)
+	}
+	return strconv.Itoa(lo)
+}
+
+func splitLines(data []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
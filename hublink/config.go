@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/misctools/hublink/forgelink"
+)
+
+// A config holds user settings read from the config file. The file consists
+// of lines of whitespace-separated words; blank lines and lines beginning
+// with "#" are ignored. The first word of each line is a keyword:
+//
+//	forge <host> <type> [<base-url>]
+//
+// declares that the remote host uses the specified forge type (one of
+// github, gitlab, bitbucket, gitea, forgejo, or sourcehut). If a base URL is
+// given, links use it in place of "https://<host>".
+//
+//	browser <command> [<args>...]
+//	clipboard <command> [<args>...]
+//
+// set the commands used to open links (-open) and copy output (-copy). An
+// argument "%s" to the browser command is replaced by the URL; otherwise the
+// URL is appended. The clipboard command reads from standard input.
+type config struct {
+	Forges    map[string]forgelink.HostConfig // keyed by hostname
+	Browser   []string                        // command to open a URL
+	Clipboard []string                        // command to copy stdin to the clipboard
+}
+
+// configPath returns the path of the config file. The HUBLINK_CONFIG
+// environment variable overrides the default location.
+func configPath() string {
+	if p := os.Getenv("HUBLINK_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".hublink"
+	}
+	return filepath.Join(dir, "hublink", "config")
+}
+
+// loadConfig reads the config file. It is not an error if the file does not
+// exist.
+func loadConfig() (*config, error) {
+	cfg := &config{Forges: make(map[string]forgelink.HostConfig)}
+	path := configPath()
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for ln := 1; sc.Scan(); ln++ {
+		words := strings.Fields(sc.Text())
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+		switch words[0] {
+		case "forge":
+			if len(words) < 3 || len(words) > 4 {
+				return nil, fmt.Errorf("%s:%d: usage: forge <host> <type> [<base-url>]", path, ln)
+			}
+			fh := forgelink.HostConfig{Kind: strings.ToLower(words[2])}
+			if _, ok := forgelink.Forges[fh.Kind]; !ok {
+				return nil, fmt.Errorf("%s:%d: unknown forge type %q", path, ln, words[2])
+			}
+			if len(words) == 4 {
+				fh.Base = words[3]
+			}
+			cfg.Forges[strings.ToLower(words[1])] = fh
+		case "browser", "clipboard":
+			if len(words) < 2 {
+				return nil, fmt.Errorf("%s:%d: usage: %s <command> [<args>...]", path, ln, words[0])
+			}
+			if words[0] == "browser" {
+				cfg.Browser = words[1:]
+			} else {
+				cfg.Clipboard = words[1:]
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown keyword %q", path, ln, words[0])
+		}
+	}
+	return cfg, sc.Err()
+}
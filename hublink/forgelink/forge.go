@@ -0,0 +1,322 @@
+package forgelink
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Forge describes how to construct URLs for one type of code hosting
+// service. The URL templates may contain the following placeholders:
+//
+//	{base}  the base URL of the forge, e.g., "https://github.com"
+//	{repo}  the repository path, e.g., "creachadair/misctools"
+//	{ref}   the branch name or commit hash
+//	{qref}  the same as {ref}, escaped for use in a URL query
+//	{kind}  "commit" if {ref} is a commit hash, otherwise "branch"
+//	{from}  the base revision of a comparison
+//	{to}    the head revision of a comparison
+//	{path}  the path of the file or directory relative to the repository root
+//	{lo}    the first line of a range
+//	{hi}    the last line of a range
+//	{lc}    the column on the first line of a range
+//	{hc}    the column on the last line of a range
+//
+// An empty template means the forge does not support that kind of link.
+type Forge struct {
+	Kind     string // the forge type, e.g., "github"
+	Blob     string // template for a link to a file
+	Tree     string // template for a link to a directory
+	Raw      string // template for a link to raw file content
+	Line     string // template for a single line anchor
+	Range    string // template for a range anchor
+	LineCol  string // template for a single line anchor with a column
+	RangeCol string // template for a range anchor with columns
+	Commit   string // template for a link to a commit
+	Compare  string // template for a link to a comparison of two revisions
+	Blame    string // template for a link to the blame view of a file
+	History  string // template for a link to the history of a file
+	Pull     string // template for a link to the pull requests for a branch
+}
+
+// Forges maps the names of the supported forge types to their templates.
+var Forges = map[string]*Forge{
+	"github": {
+		Kind:  "github",
+		Blob:  "{base}/{repo}/blob/{ref}/{path}",
+		Tree:  "{base}/{repo}/tree/{ref}/{path}",
+		Raw:   "{base}/{repo}/raw/{ref}/{path}",
+		Line:  "#L{lo}",
+		Range: "#L{lo}-L{hi}",
+
+		LineCol:  "#L{lo}C{lc}",
+		RangeCol: "#L{lo}C{lc}-L{hi}C{hc}",
+
+		Commit:  "{base}/{repo}/commit/{ref}",
+		Compare: "{base}/{repo}/compare/{from}...{to}",
+		Blame:   "{base}/{repo}/blame/{ref}/{path}",
+		History: "{base}/{repo}/commits/{ref}/{path}",
+		Pull:    "{base}/{repo}/pulls?q=is%3Apr+head%3A{qref}",
+	},
+	"gitlab": {
+		Kind:  "gitlab",
+		Blob:  "{base}/{repo}/-/blob/{ref}/{path}",
+		Tree:  "{base}/{repo}/-/tree/{ref}/{path}",
+		Raw:   "{base}/{repo}/-/raw/{ref}/{path}",
+		Line:  "#L{lo}",
+		Range: "#L{lo}-{hi}",
+
+		Commit:  "{base}/{repo}/-/commit/{ref}",
+		Compare: "{base}/{repo}/-/compare/{from}...{to}",
+		Blame:   "{base}/{repo}/-/blame/{ref}/{path}",
+		History: "{base}/{repo}/-/commits/{ref}/{path}",
+		Pull:    "{base}/{repo}/-/merge_requests?scope=all&state=all&source_branch={qref}",
+	},
+	"bitbucket": {
+		Kind:  "bitbucket",
+		Blob:  "{base}/{repo}/src/{ref}/{path}",
+		Tree:  "{base}/{repo}/src/{ref}/{path}",
+		Raw:   "{base}/{repo}/raw/{ref}/{path}",
+		Line:  "#lines-{lo}",
+		Range: "#lines-{lo}:{hi}",
+
+		Commit:  "{base}/{repo}/commits/{ref}",
+		Compare: "{base}/{repo}/branches/compare/{to}%0D{from}",
+		Blame:   "{base}/{repo}/annotate/{ref}/{path}",
+		History: "{base}/{repo}/history-node/{ref}/{path}",
+		Pull:    "{base}/{repo}/pull-requests/new?source={qref}",
+	},
+	"gitea": {
+		Kind:  "gitea",
+		Blob:  "{base}/{repo}/src/{kind}/{ref}/{path}",
+		Tree:  "{base}/{repo}/src/{kind}/{ref}/{path}",
+		Raw:   "{base}/{repo}/raw/{kind}/{ref}/{path}",
+		Line:  "#L{lo}",
+		Range: "#L{lo}-L{hi}",
+
+		Commit:  "{base}/{repo}/commit/{ref}",
+		Compare: "{base}/{repo}/compare/{from}...{to}",
+		Blame:   "{base}/{repo}/blame/{kind}/{ref}/{path}",
+		History: "{base}/{repo}/commits/{kind}/{ref}/{path}",
+		Pull:    "{base}/{repo}/pulls?state=all&q={qref}",
+	},
+	"sourcehut": {
+		Kind:  "sourcehut",
+		Blob:  "{base}/{repo}/tree/{ref}/item/{path}",
+		Tree:  "{base}/{repo}/tree/{ref}/item/{path}",
+		Raw:   "{base}/{repo}/blob/{ref}/{path}",
+		Line:  "#L{lo}",
+		Range: "#L{lo}-{hi}",
+
+		// Sourcehut has no compare view, and patches are reviewed on mailing
+		// lists rather than as pull requests.
+		Commit:  "{base}/{repo}/commit/{ref}",
+		Blame:   "{base}/{repo}/blame/{ref}/{path}",
+		History: "{base}/{repo}/log/{ref}/item/{path}",
+	},
+}
+
+func init() {
+	Forges["forgejo"] = Forges["gitea"]
+	Forges["srht"] = Forges["sourcehut"]
+
+	gh := *Forges["github"]
+	gh.Raw = "https://raw.githubusercontent.com/{repo}/{ref}/{path}"
+	KnownHosts["github.com"] = &gh
+}
+
+// KnownHosts maps the hostnames of well-known public forges to their types.
+// Other hosts must be configured explicitly, or have a recognizable name.
+var KnownHosts = map[string]*Forge{
+	"gitlab.com":    Forges["gitlab"],
+	"bitbucket.org": Forges["bitbucket"],
+	"gitea.com":     Forges["gitea"],
+	"codeberg.org":  Forges["gitea"],
+	"git.sr.ht":     Forges["sourcehut"],
+}
+
+// A HostConfig declares the forge type of a self-hosted forge.
+type HostConfig struct {
+	Kind string // forge type, a key of Forges
+	Base string // base URL, or "" for "https://<host>"
+}
+
+// ErrUnknownForge is reported by FindRemote when the forge type of a host
+// cannot be determined.
+var ErrUnknownForge = errors.New("unknown forge")
+
+// A Remote is a repository hosted on a forge.
+type Remote struct {
+	*Forge
+	Host string // the hostname of the remote, e.g., "github.com"
+	Base string // the base URL of the web interface, e.g., "https://github.com"
+	Name string // the repository path, e.g., "creachadair/misctools"
+
+	Local string // the name of the local remote, if known
+}
+
+// FindRemote returns the repository hosted at the location of the remote URL
+// ru, consulting hosts for hosts that are not well-known.
+//
+// Unless hosts gives a base URL, the web interface is assumed to be served
+// over HTTPS from the host of ru. For an HTTP or HTTPS remote, the scheme and
+// port of ru are used instead; the port of any other remote, such as SSH,
+// does not serve the web interface, and is dropped.
+func FindRemote(hosts map[string]HostConfig, ru *RemoteURL) (*Remote, error) {
+	host := ru.Host
+	r := &Remote{Host: host, Base: "https://" + hostPort(host, ""), Name: ru.Path}
+	if ru.Scheme == "http" || ru.Scheme == "https" {
+		r.Base = ru.Scheme + "://" + hostPort(host, ru.Port)
+	}
+	if hc, ok := hosts[host]; ok {
+		f, ok := Forges[hc.Kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown forge type %q for host %q", hc.Kind, host)
+		}
+		r.Forge = f
+		if hc.Base != "" {
+			r.Base = strings.TrimSuffix(hc.Base, "/")
+		}
+		return r, nil
+	}
+	if f, ok := KnownHosts[host]; ok {
+		r.Forge = f
+		return r, nil
+	}
+
+	// As a last resort, guess from the hostname, e.g., "gitlab.example.com".
+	for _, kind := range []string{"github", "gitlab", "bitbucket", "gitea", "forgejo"} {
+		if strings.Contains(host, kind) {
+			r.Forge = Forges[kind]
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("%w for host %q", ErrUnknownForge, host)
+}
+
+// hostPort joins host and port, if any, bracketing an IPv6 address.
+func hostPort(host, port string) string {
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+var isHash = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// IsHash reports whether s is a full commit hash (SHA-1 or SHA-256).
+func IsHash(s string) bool { return isHash.MatchString(s) }
+
+// linkArgs are the values substituted into a URL template.
+type linkArgs struct {
+	Ref, From, To string
+	Path          string
+	Range
+}
+
+// expand returns tmpl with its placeholders replaced by the values in a.
+func (r *Remote) expand(tmpl string, a linkArgs) string {
+	kind := "branch"
+	if isHash.MatchString(a.Ref) {
+		kind = "commit"
+	}
+	return strings.NewReplacer(
+		"{base}", r.Base,
+		"{repo}", r.Name,
+		"{ref}", a.Ref,
+		"{qref}", url.QueryEscape(a.Ref),
+		"{kind}", kind,
+		"{from}", a.From,
+		"{to}", a.To,
+		"{path}", a.Path,
+		"{lo}", strconv.Itoa(a.Lo),
+		"{hi}", strconv.Itoa(a.Hi),
+		"{lc}", strconv.Itoa(a.LoCol),
+		"{hc}", strconv.Itoa(a.HiCol),
+	).Replace(tmpl)
+}
+
+// link returns the expansion of tmpl, or an error if tmpl is empty,
+// meaning the forge does not support what is described.
+func (r *Remote) link(what, tmpl string, a linkArgs) (string, error) {
+	if tmpl == "" {
+		return "", fmt.Errorf("%s does not support links to %s", r.Kind, what)
+	}
+	return r.expand(tmpl, a), nil
+}
+
+// anchor returns tmpl with a line or range anchor added, if a specifies one.
+// Columns are included if the forge supports them.
+func (r *Remote) anchor(tmpl string, a linkArgs) string {
+	if a.Lo > 0 && a.LoCol > 0 && a.HiCol > 0 && a.Hi >= a.Lo && r.RangeCol != "" {
+		return tmpl + r.RangeCol
+	} else if a.Lo > 0 && a.LoCol > 0 && a.Hi <= a.Lo && r.LineCol != "" {
+		return tmpl + r.LineCol
+	} else if a.Lo > 0 && a.Hi > a.Lo {
+		return tmpl + r.Range
+	} else if a.Lo > 0 {
+		return tmpl + r.Line
+	}
+	return tmpl
+}
+
+// FileURL returns a link to the specified path at ref. If lr.Lo > 0, the link
+// is to that line, or to the range of lines lr.Lo..lr.Hi if lr.Hi > lr.Lo.
+// If isTree is true, path is a directory, and "" denotes the root.
+func (r *Remote) FileURL(ref, path string, isTree bool, lr Range) string {
+	a := linkArgs{Ref: ref, Path: path, Range: lr}
+	if isTree {
+		link := r.expand(r.Tree, a)
+		if path == "" {
+			link = strings.TrimSuffix(link, "/")
+		}
+		return link
+	}
+	return r.expand(r.anchor(r.Blob, a), a)
+}
+
+// RawURL returns a link to the raw content of the specified path at ref.
+func (r *Remote) RawURL(ref, path string) string {
+	return r.expand(r.Raw, linkArgs{Ref: ref, Path: path})
+}
+
+// CommitURL returns a link to the specified commit.
+func (r *Remote) CommitURL(hash string) (string, error) {
+	return r.link("commits", r.Commit, linkArgs{Ref: hash})
+}
+
+// CompareURL returns a link to a comparison of revisions from and to.
+func (r *Remote) CompareURL(from, to string) (string, error) {
+	return r.link("comparisons", r.Compare, linkArgs{From: from, To: to})
+}
+
+// BlameURL returns a link to the blame view of path at ref, anchored at the
+// specified lines if lr.Lo > 0.
+func (r *Remote) BlameURL(ref, path string, lr Range) (string, error) {
+	a := linkArgs{Ref: ref, Path: path, Range: lr}
+	if r.Blame == "" {
+		return r.link("blame", "", a)
+	}
+	return r.expand(r.anchor(r.Blame, a), a), nil
+}
+
+// HistoryURL returns a link to the history of path at ref. If path == "",
+// the link is to the history of the whole repository.
+func (r *Remote) HistoryURL(ref, path string) (string, error) {
+	link, err := r.link("history", r.History, linkArgs{Ref: ref, Path: path})
+	if path == "" {
+		link = strings.TrimSuffix(link, "/")
+	}
+	return link, err
+}
+
+// PullURL returns a link to the pull requests for the specified branch.
+func (r *Remote) PullURL(branch string) (string, error) {
+	return r.link("pull requests", r.Pull, linkArgs{Ref: branch})
+}
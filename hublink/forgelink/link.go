@@ -0,0 +1,159 @@
+// Package forgelink constructs links to files, commits, and other objects in
+// git repositories hosted on GitHub and other forges.
+//
+// A Linker combines a local Repo with the forge that hosts one of its
+// remotes. The Git type implements Repo using the git command-line tool;
+// other implementations may be supplied for testing, or for editors that
+// already know the state of the repository.
+package forgelink
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// A Link is a link to a file or directory in a hosted repository.
+type Link struct {
+	Remote *Remote
+	Ref    string // the branch name or commit hash
+	Path   string // slash-separated, relative to the repository root
+	Range         // the linked lines, if any
+	Dir    bool   // the path is a directory
+	Raw    bool   // link to the raw content rather than the web view
+}
+
+// URL returns the URL of the link.
+func (l *Link) URL() string {
+	if l.Raw {
+		return l.Remote.RawURL(l.Ref, l.Path)
+	}
+	return l.Remote.FileURL(l.Ref, l.Path, l.Dir, l.Range)
+}
+
+// A Linker generates links to the contents of a local repository.
+type Linker struct {
+	Repo Repo
+
+	// Hosts declares the forge types of hosts that are not well-known.
+	Hosts map[string]HostConfig
+
+	// Remote is the name of the remote to link to. If empty, the remote
+	// tracked by the current branch is used, then "origin", then the first
+	// remote defined.
+	Remote string
+
+	// Branch is the branch to link to. If empty, the current branch is used,
+	// or the commit at HEAD if it is detached.
+	Branch string
+
+	// If UseHash is true, links use the commit hash of the branch rather than
+	// its name.
+	UseHash bool
+}
+
+// SelectRemote returns the name of the remote to link to, as described for
+// the Remote field.
+func (k *Linker) SelectRemote() (string, error) {
+	rems, err := k.Repo.Remotes()
+	if err != nil {
+		return "", fmt.Errorf("listing remotes: %v", err)
+	} else if len(rems) == 0 {
+		return "", fmt.Errorf("no remotes are defined")
+	}
+	has := func(s string) bool {
+		for _, r := range rems {
+			if r == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	if k.Remote != "" {
+		if !has(k.Remote) {
+			return "", fmt.Errorf("no remote named %q", k.Remote)
+		}
+		return k.Remote, nil
+	}
+	if cur, err := k.Repo.CurrentBranch(); err == nil && cur != "" {
+		if tr, err := k.Repo.BranchRemote(cur); err == nil && has(tr) {
+			return tr, nil
+		}
+	}
+	if has("origin") {
+		return "origin", nil
+	}
+	return rems[0], nil
+}
+
+// FindRemote returns the hosted repository of the selected remote.
+func (k *Linker) FindRemote() (*Remote, error) {
+	name, err := k.SelectRemote()
+	if err != nil {
+		return nil, err
+	}
+	url, err := k.Repo.RemoteURL(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting remote URL: %v", err)
+	}
+	ru, err := ParseRemoteURL(url)
+	if err != nil {
+		return nil, err
+	}
+	r, err := FindRemote(k.Hosts, ru)
+	if err != nil {
+		return nil, err
+	}
+	r.Local = name
+	return r, nil
+}
+
+// Target returns the branch name or commit hash to link to, as described for
+// the Branch and UseHash fields.
+func (k *Linker) Target() (string, error) {
+	branch := k.Branch
+	if branch == "" {
+		cur, err := k.Repo.CurrentBranch()
+		if err != nil {
+			return "", fmt.Errorf("finding current branch: %v", err)
+		}
+		if cur == "" {
+			return k.Repo.ResolveCommit("HEAD") // detached HEAD
+		}
+		branch = cur
+	}
+	if k.UseHash {
+		return k.Repo.ResolveCommit(branch)
+	}
+	return branch, nil
+}
+
+// File returns a link to the file or directory described by spec, in the
+// format accepted by ParseSpec.
+func (k *Linker) File(spec string) (*Link, error) {
+	r, err := k.FindRemote()
+	if err != nil {
+		return nil, err
+	}
+	target, err := k.Target()
+	if err != nil {
+		return nil, fmt.Errorf("resolving branch: %v", err)
+	}
+	s, err := ParseSpec(k.Repo, spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file spec: %v", err)
+	}
+	return k.SpecLink(r, target, s), nil
+}
+
+// SpecLink returns a link to s in r at target.
+func (k *Linker) SpecLink(r *Remote, target string, s Spec) *Link {
+	l := &Link{Remote: r, Ref: target, Path: s.Path, Range: s.Range}
+	if l.Path == "." {
+		l.Path = ""
+	}
+	if fi, err := fs.Stat(k.Repo, s.Path); err == nil && fi.IsDir() {
+		l.Dir = true
+	}
+	return l
+}
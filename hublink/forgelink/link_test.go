@@ -0,0 +1,321 @@
+package forgelink
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeRepo is a Repo with fixed contents, for testing.
+type fakeRepo struct {
+	fstest.MapFS
+	root, wd string
+	branch   string            // current branch, "" if detached
+	commits  map[string]string // rev → commit hash
+	remotes  []string
+	urls     map[string]string // remote → URL
+	tracking map[string]string // branch → remote
+}
+
+func (f *fakeRepo) Root() (string, error)          { return f.root, nil }
+func (f *fakeRepo) WorkDir() (string, error)       { return f.wd, nil }
+func (f *fakeRepo) CurrentBranch() (string, error) { return f.branch, nil }
+func (f *fakeRepo) Remotes() ([]string, error)     { return f.remotes, nil }
+
+func (f *fakeRepo) ResolveCommit(rev string) (string, error) {
+	if h, ok := f.commits[rev]; ok {
+		return h, nil
+	}
+	return "", fmt.Errorf("unknown revision %q", rev)
+}
+
+func (f *fakeRepo) RemoteURL(name string) (string, error) {
+	if u, ok := f.urls[name]; ok {
+		return u, nil
+	}
+	return "", fmt.Errorf("no such remote %q", name)
+}
+
+func (f *fakeRepo) BranchRemote(branch string) (string, error) { return f.tracking[branch], nil }
+
+const (
+	hashMain  = "1111111111111111111111111111111111111111"
+	hashTopic = "2222222222222222222222222222222222222222"
+)
+
+var goSource = `package p
+
+type T int
+
+func (t *T) Get() int {
+	return int(*t)
+}
+
+func F() {}
+`
+
+func newFake() *fakeRepo {
+	root := filepath.FromSlash("/src/repo")
+	return &fakeRepo{
+		MapFS: fstest.MapFS{
+			"README.md":   {Data: []byte("# Title\n\nSome text.\nMore text.\n")},
+			"lib/p.go":    {Data: []byte(goSource)},
+			"lib/a:b.txt": {Data: []byte("one\ntwo\n")},
+		},
+		root:   root,
+		wd:     root,
+		branch: "topic",
+		commits: map[string]string{
+			"HEAD":  hashTopic,
+			"topic": hashTopic,
+			"main":  hashMain,
+		},
+		remotes: []string{"origin", "upstream"},
+		urls: map[string]string{
+			"origin":   "git@github.com:me/repo.git",
+			"upstream": "https://gitlab.example.com/team/repo",
+		},
+		tracking: map[string]string{"main": "upstream"},
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		wd, input string
+		want      Spec
+	}{
+		{"", "README.md", Spec{Path: "README.md"}},
+		{"", "README.md:3", Spec{Path: "README.md", Range: Range{Lo: 3}}},
+		{"", "README.md:3-4", Spec{Path: "README.md", Range: Range{Lo: 3, Hi: 4}}},
+		{"", "README.md:3.2-4.5", Spec{Path: "README.md", Range: Range{Lo: 3, Hi: 4, LoCol: 2, HiCol: 5}}},
+		{"", "README.md:@text", Spec{Path: "README.md", Range: Range{Lo: 3, Hi: 3}}},
+		{"", "README.md:@text#2", Spec{Path: "README.md", Range: Range{Lo: 4, Hi: 4}}},
+		{"", "README.md:@Some.*More", Spec{Path: "README.md", Range: Range{Lo: 3, Hi: 4}}},
+		{"", "lib/p.go:#F", Spec{Path: "lib/p.go", Range: Range{Lo: 9, Hi: 9}}},
+		{"", "lib/p.go:#T", Spec{Path: "lib/p.go", Range: Range{Lo: 3, Hi: 3}}},
+		{"", "lib/p.go:#T.Get", Spec{Path: "lib/p.go", Range: Range{Lo: 5, Hi: 7}}},
+		{"", "lib/p.go:#(*T).Get", Spec{Path: "lib/p.go", Range: Range{Lo: 5, Hi: 7}}},
+		{"", "lib/p.go:#Get", Spec{Path: "lib/p.go", Range: Range{Lo: 5, Hi: 7}}},
+		{"", "lib/a:b.txt", Spec{Path: "lib/a:b.txt"}},
+		{"", "lib/a:b.txt:2", Spec{Path: "lib/a:b.txt", Range: Range{Lo: 2}}},
+		{"", "lib", Spec{Path: "lib"}},
+		{"", ".", Spec{Path: "."}},
+		{"lib", "p.go:4", Spec{Path: "lib/p.go", Range: Range{Lo: 4}}},
+		{"lib", "../README.md", Spec{Path: "README.md"}},
+		{"", "/src/repo/README.md:1", Spec{Path: "README.md", Range: Range{Lo: 1}}},
+	}
+	for _, test := range tests {
+		repo := newFake()
+		repo.wd = filepath.Join(repo.root, test.wd)
+		got, err := ParseSpec(repo, test.input)
+		if err != nil {
+			t.Errorf("ParseSpec(%q) [wd %q]: unexpected error: %v", test.input, test.wd, err)
+			continue
+		}
+		if got.Path != test.want.Path || got.Range != test.want.Range {
+			t.Errorf("ParseSpec(%q) [wd %q]: got %+v, want %+v", test.input, test.wd, got, test.want)
+		}
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	tests := []string{
+		"../elsewhere.txt",
+		"README.md:@nonesuch",
+		"README.md:@text#3",
+		"README.md:@(",
+		"README.md:#F",
+		"lib/p.go:#Nonesuch",
+		"lib/p.go:#T.Put",
+		"nonesuch.go:#F",
+	}
+	for _, input := range tests {
+		got, err := ParseSpec(newFake(), input)
+		if err == nil {
+			t.Errorf("ParseSpec(%q): got %+v, want error", input, got)
+		}
+	}
+}
+
+func TestRemoteURLs(t *testing.T) {
+	ref := Range{Lo: 5, Hi: 10}
+	cols := Range{Lo: 5, Hi: 10, LoCol: 2, HiCol: 3}
+	tests := []struct {
+		kind string
+		got  func(r *Remote) (string, error)
+		want string
+	}{
+		{"github", func(r *Remote) (string, error) { return r.FileURL("main", "a/b.go", false, Range{}), nil },
+			"https://h/o/r/blob/main/a/b.go"},
+		{"github", func(r *Remote) (string, error) { return r.FileURL("main", "a/b.go", false, ref), nil },
+			"https://h/o/r/blob/main/a/b.go#L5-L10"},
+		{"github", func(r *Remote) (string, error) { return r.FileURL("main", "a/b.go", false, cols), nil },
+			"https://h/o/r/blob/main/a/b.go#L5C2-L10C3"},
+		{"github", func(r *Remote) (string, error) { return r.FileURL("main", "a", true, Range{}), nil },
+			"https://h/o/r/tree/main/a"},
+		{"github", func(r *Remote) (string, error) { return r.FileURL("main", "", true, Range{}), nil },
+			"https://h/o/r/tree/main"},
+		{"github", func(r *Remote) (string, error) { return r.CompareURL("v1", "main") },
+			"https://h/o/r/compare/v1...main"},
+		{"github", func(r *Remote) (string, error) { return r.PullURL("x/y") },
+			"https://h/o/r/pulls?q=is%3Apr+head%3Ax%2Fy"},
+		{"gitlab", func(r *Remote) (string, error) { return r.FileURL("main", "a/b.go", false, cols), nil },
+			"https://h/o/r/-/blob/main/a/b.go#L5-10"},
+		{"gitlab", func(r *Remote) (string, error) { return r.BlameURL("main", "a/b.go", Range{Lo: 5}) },
+			"https://h/o/r/-/blame/main/a/b.go#L5"},
+		{"gitlab", func(r *Remote) (string, error) { return r.HistoryURL("main", "") },
+			"https://h/o/r/-/commits/main"},
+		{"bitbucket", func(r *Remote) (string, error) { return r.FileURL("main", "a/b.go", false, ref), nil },
+			"https://h/o/r/src/main/a/b.go#lines-5:10"},
+		{"bitbucket", func(r *Remote) (string, error) { return r.CompareURL("v1", "main") },
+			"https://h/o/r/branches/compare/main%0Dv1"},
+		{"gitea", func(r *Remote) (string, error) { return r.FileURL("main", "a/b.go", false, Range{Lo: 5}), nil },
+			"https://h/o/r/src/branch/main/a/b.go#L5"},
+		{"gitea", func(r *Remote) (string, error) { return r.FileURL(hashMain, "a/b.go", false, Range{}), nil },
+			"https://h/o/r/src/commit/" + hashMain + "/a/b.go"},
+		{"gitea", func(r *Remote) (string, error) { return r.RawURL("main", "a/b.go"), nil },
+			"https://h/o/r/raw/branch/main/a/b.go"},
+		{"sourcehut", func(r *Remote) (string, error) { return r.CommitURL(hashMain) },
+			"https://h/o/r/commit/" + hashMain},
+		{"sourcehut", func(r *Remote) (string, error) { return r.HistoryURL("main", "a") },
+			"https://h/o/r/log/main/item/a"},
+	}
+	for _, test := range tests {
+		r := &Remote{Forge: Forges[test.kind], Host: "h", Base: "https://h", Name: "o/r"}
+		got, err := test.got(r)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.kind, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.kind, got, test.want)
+		}
+	}
+
+	// Sourcehut does not support comparisons or pull requests.
+	r := &Remote{Forge: Forges["sourcehut"], Host: "h", Base: "https://h", Name: "o/r"}
+	if got, err := r.CompareURL("a", "b"); err == nil {
+		t.Errorf("sourcehut CompareURL: got %q, want error", got)
+	}
+	if got, err := r.PullURL("a"); err == nil {
+		t.Errorf("sourcehut PullURL: got %q, want error", got)
+	}
+}
+
+func TestFindRemote(t *testing.T) {
+	hosts := map[string]HostConfig{
+		"git.corp.example": {Kind: "gitlab", Base: "https://code.corp.example/"},
+		"bogus.example":    {Kind: "nonesuch"},
+	}
+	tests := []struct {
+		remote, kind, base string
+	}{
+		{"git@github.com:o/r.git", "github", "https://github.com"},
+		{"https://codeberg.org/o/r", "gitea", "https://codeberg.org"},
+		{"https://git.sr.ht/o/r", "sourcehut", "https://git.sr.ht"},
+		{"ssh://git@git.corp.example:2222/o/r", "gitlab", "https://code.corp.example"},
+		{"https://github.example.com/o/r", "github", "https://github.example.com"},
+		{"ssh://git@forgejo.example.com:2222/o/r", "gitea", "https://forgejo.example.com"},
+		{"https://gitlab.example.com:8443/o/r.git", "gitlab", "https://gitlab.example.com:8443"},
+		{"http://gitea.example.com:3000/o/r", "gitea", "http://gitea.example.com:3000"},
+		{"git@[::1]:o/r", "", ""},
+	}
+	for _, test := range tests {
+		ru, err := ParseRemoteURL(test.remote)
+		if err != nil {
+			t.Fatalf("ParseRemoteURL(%q): %v", test.remote, err)
+		}
+		r, err := FindRemote(hosts, ru)
+		if test.kind == "" {
+			if err == nil {
+				t.Errorf("FindRemote(%q): got %+v, want error", test.remote, r)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("FindRemote(%q): unexpected error: %v", test.remote, err)
+			continue
+		}
+		if r.Kind != test.kind || r.Base != test.base || r.Name != "o/r" {
+			t.Errorf("FindRemote(%q): got kind %q, base %q, name %q; want %q, %q, %q",
+				test.remote, r.Kind, r.Base, r.Name, test.kind, test.base, "o/r")
+		}
+	}
+
+	if r, err := FindRemote(hosts, &RemoteURL{Host: "git.example.com", Path: "o/r"}); !errors.Is(err, ErrUnknownForge) {
+		t.Errorf("FindRemote(unknown): got %+v, %v; want %v", r, err, ErrUnknownForge)
+	}
+	if r, err := FindRemote(hosts, &RemoteURL{Host: "bogus.example", Path: "o/r"}); err == nil {
+		t.Errorf("FindRemote(bogus): got %+v, want error", r)
+	}
+}
+
+func TestLinker(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(*fakeRepo, *Linker)
+		spec   string
+		want   string
+		remote string
+	}{
+		{"default", nil, "README.md:3",
+			"https://github.com/me/repo/blob/topic/README.md#L3", "origin"},
+		{"directory", nil, "lib",
+			"https://github.com/me/repo/tree/topic/lib", "origin"},
+		{"root", nil, ".",
+			"https://github.com/me/repo/tree/topic", "origin"},
+		{"hash", func(_ *fakeRepo, k *Linker) { k.UseHash = true }, "lib/p.go:#F",
+			"https://github.com/me/repo/blob/" + hashTopic + "/lib/p.go#L9", "origin"},
+		{"branch", func(_ *fakeRepo, k *Linker) { k.Branch = "main" }, "README.md",
+			"https://github.com/me/repo/blob/main/README.md", "origin"},
+		{"detached", func(f *fakeRepo, _ *Linker) { f.branch = "" }, "README.md",
+			"https://github.com/me/repo/blob/" + hashTopic + "/README.md", "origin"},
+		{"tracking", func(f *fakeRepo, _ *Linker) { f.branch = "main" }, "README.md:1-2",
+			"https://gitlab.example.com/team/repo/-/blob/main/README.md#L1-2", "upstream"},
+		{"explicit remote", func(_ *fakeRepo, k *Linker) { k.Remote = "upstream" }, "README.md",
+			"https://gitlab.example.com/team/repo/-/blob/topic/README.md", "upstream"},
+		{"first remote", func(f *fakeRepo, _ *Linker) { f.remotes = []string{"upstream", "zzz"} }, "README.md",
+			"https://gitlab.example.com/team/repo/-/blob/topic/README.md", "upstream"},
+		{"raw", nil, "README.md",
+			"https://raw.githubusercontent.com/me/repo/topic/README.md", "origin"},
+	}
+	for _, test := range tests {
+		repo := newFake()
+		k := &Linker{Repo: repo}
+		if test.setup != nil {
+			test.setup(repo, k)
+		}
+		link, err := k.File(test.spec)
+		if err != nil {
+			t.Errorf("%s: File(%q): unexpected error: %v", test.name, test.spec, err)
+			continue
+		}
+		link.Raw = test.name == "raw"
+		if got := link.URL(); got != test.want {
+			t.Errorf("%s: File(%q): got %q, want %q", test.name, test.spec, got, test.want)
+		}
+		if link.Remote.Local != test.remote {
+			t.Errorf("%s: File(%q): remote is %q, want %q", test.name, test.spec, link.Remote.Local, test.remote)
+		}
+	}
+}
+
+func TestLinkerErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*fakeRepo, *Linker)
+	}{
+		{"no remotes", func(f *fakeRepo, _ *Linker) { f.remotes = nil }},
+		{"missing remote", func(_ *fakeRepo, k *Linker) { k.Remote = "nonesuch" }},
+		{"bad remote URL", func(f *fakeRepo, _ *Linker) { f.urls["origin"] = "/local/path" }},
+		{"unknown forge", func(f *fakeRepo, _ *Linker) { f.urls["origin"] = "git@git.example.com:o/r" }},
+		{"unknown branch", func(_ *fakeRepo, k *Linker) { k.Branch, k.UseHash = "nonesuch", true }},
+	}
+	for _, test := range tests {
+		repo := newFake()
+		k := &Linker{Repo: repo}
+		test.setup(repo, k)
+		if link, err := k.File("README.md"); err == nil {
+			t.Errorf("%s: got %q, want error", test.name, link.URL())
+		}
+	}
+}
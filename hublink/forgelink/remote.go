@@ -0,0 +1,79 @@
+package forgelink
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A RemoteURL is the parsed form of a git remote URL.
+type RemoteURL struct {
+	Scheme string // e.g., "https", "ssh"; "" for SCP-style remotes
+	User   string // user name, if any (passwords are discarded)
+	Host   string // hostname, in lower case
+	Port   string // port, if specified
+	Path   string // repository path without leading or trailing slashes or ".git"
+}
+
+// ParseRemoteURL parses a git remote URL. It accepts URLs with a scheme
+// (ssh, git+ssh, ssh+git, git, http, https) and SCP-style remotes of the form
+// [user@]host:path. Local paths and file URLs are rejected, since they do
+// not correspond to a forge.
+//
+// Note that git applies url.<base>.insteadOf rewrites when reporting remote
+// URLs via "git remote get-url", so those are handled before we see them.
+func ParseRemoteURL(s string) (*RemoteURL, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty remote URL")
+	}
+
+	var r RemoteURL
+	if i := strings.Index(s, "://"); i >= 0 {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote URL: %v", err)
+		}
+		switch u.Scheme {
+		case "ssh", "git+ssh", "ssh+git", "git", "http", "https":
+		case "file":
+			return nil, fmt.Errorf("remote %q is a local path", s)
+		default:
+			return nil, fmt.Errorf("unsupported remote URL scheme %q", u.Scheme)
+		}
+		r.Scheme = u.Scheme
+		if u.User != nil {
+			r.User = u.User.Username()
+		}
+		r.Host, r.Port = u.Hostname(), u.Port()
+		r.Path = u.Path
+	} else {
+		// An SCP-style remote has a colon before the first slash. Following
+		// git, a single-letter "host" is a drive letter on a local path.
+		// Bracketed IPv6 addresses may appear here, e.g., git@[::1]:repo.
+		colon, slash := strings.Index(s, ":"), strings.Index(s, "/")
+		if i := strings.Index(s, "]:"); i >= 0 && strings.Contains(s[:i], "[") {
+			colon = i + 1
+		}
+		if colon < 0 || (slash >= 0 && slash < colon) || colon == 1 {
+			return nil, fmt.Errorf("remote %q is a local path", s)
+		}
+		host := s[:colon]
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			r.User, host = host[:i], host[i+1:]
+		}
+		r.Host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		r.Path = s[colon+1:]
+	}
+
+	r.Host = strings.ToLower(r.Host)
+	r.Path = strings.Trim(r.Path, "/")
+	r.Path = strings.TrimSuffix(r.Path, ".git")
+	r.Path = strings.TrimSuffix(r.Path, "/")
+	if r.Host == "" {
+		return nil, fmt.Errorf("remote URL %q has no host", s)
+	} else if r.Path == "" {
+		return nil, fmt.Errorf("remote URL %q has no repository path", s)
+	}
+	return &r, nil
+}
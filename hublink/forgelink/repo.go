@@ -0,0 +1,134 @@
+package forgelink
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Repo provides access to a local git repository. The Git type implements
+// it by running git; tests and other callers may supply their own.
+//
+// The file system of a Repo is its working tree: names are slash-separated
+// and relative to the repository root.
+type Repo interface {
+	fs.FS
+
+	// Root returns the absolute path of the root of the working tree.
+	Root() (string, error)
+
+	// WorkDir returns the absolute path of the directory relative to which
+	// file specs are interpreted.
+	WorkDir() (string, error)
+
+	// CurrentBranch returns the name of the checked-out branch, or "" if
+	// HEAD is detached.
+	CurrentBranch() (string, error)
+
+	// ResolveCommit returns the commit hash named by rev.
+	ResolveCommit(rev string) (string, error)
+
+	// Remotes returns the names of the remotes, in the order git lists them.
+	Remotes() ([]string, error)
+
+	// RemoteURL returns the URL of the named remote.
+	RemoteURL(name string) (string, error)
+
+	// BranchRemote returns the name of the remote tracked by branch, or ""
+	// if it has none.
+	BranchRemote(branch string) (string, error)
+}
+
+// Git is a Repo that runs git in a directory of a local repository.
+type Git struct {
+	dir, root string
+	fsys      fs.FS
+}
+
+// NewGit returns a Repo for the repository containing dir. If dir == "", the
+// current working directory is used.
+func NewGit(dir string) (*Git, error) {
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	g := &Git{dir: dir}
+	root, err := g.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("getting repository root: %v", err)
+	}
+	g.root = filepath.FromSlash(root)
+	g.fsys = os.DirFS(g.root)
+	return g, nil
+}
+
+// Open implements fs.FS by opening files in the working tree.
+func (g *Git) Open(name string) (fs.File, error) { return g.fsys.Open(name) }
+
+// Root implements a method of Repo.
+func (g *Git) Root() (string, error) { return g.root, nil }
+
+// WorkDir implements a method of Repo. It returns the directory given to
+// NewGit.
+func (g *Git) WorkDir() (string, error) { return g.dir, nil }
+
+// CurrentBranch implements a method of Repo.
+func (g *Git) CurrentBranch() (string, error) { return g.git("branch", "--show-current") }
+
+// ResolveCommit implements a method of Repo.
+func (g *Git) ResolveCommit(rev string) (string, error) {
+	return g.git("rev-parse", "--verify", rev+"^{commit}")
+}
+
+// Remotes implements a method of Repo.
+func (g *Git) Remotes() ([]string, error) {
+	out, err := g.git("remote")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// RemoteURL implements a method of Repo.
+func (g *Git) RemoteURL(name string) (string, error) { return g.git("remote", "get-url", name) }
+
+// BranchRemote implements a method of Repo.
+func (g *Git) BranchRemote(branch string) (string, error) {
+	out, err := g.git("config", "branch."+branch+".remote")
+	var ex *exec.ExitError
+	if errors.As(err, &ex) && ex.ExitCode() == 1 {
+		return "", nil // the key is not set
+	}
+	return out, err
+}
+
+func (g *Git) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	out, err := cmd.Output()
+	if err != nil {
+		var ex *exec.ExitError
+		if errors.As(err, &ex) && len(ex.Stderr) != 0 {
+			return "", &gitError{ex, strings.SplitN(string(ex.Stderr), "\n", 2)[0]}
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// A gitError reports the first line of the diagnostic from a failed git
+// command, while preserving the underlying exit error.
+type gitError struct {
+	*exec.ExitError
+	msg string
+}
+
+func (e *gitError) Error() string { return e.msg }
+func (e *gitError) Unwrap() error { return e.ExitError }
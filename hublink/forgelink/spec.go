@@ -0,0 +1,300 @@
+package forgelink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Range is a location within a file. Columns are 1-based, and are ignored
+// by forges that do not support them.
+type Range struct {
+	Lo, Hi       int // lines; 0 means none, Hi <= Lo means a single line
+	LoCol, HiCol int // columns on lines Lo and Hi, or 0
+}
+
+// A Spec is a parsed file path with an optional location.
+type Spec struct {
+	Path  string // slash-separated, relative to the repository root
+	Local string // the path as given, without the location
+	Range
+}
+
+// ParseSpec parses a file path with an optional coda specifying a location
+// within the file. The coda may have the following forms
+//
+//	:dd
+//	:dd-dd
+//	:dd.cc-dd.cc
+//	:@re
+//	:@re#n
+//	:#name
+//
+// where "dd" represents decimal digits, "cc" a column number, "re" an RE2
+// regular expression, and "name" the name of a Go declaration. The first
+// three forms indicate a single line or range of lines, optionally with
+// columns. The @re forms indicate the line or range corresponding to the
+// first (or nth) match of the given regular expression in the file content.
+// The last form indicates the full span of the named function, method, type,
+// variable, or constant in a Go source file; methods may be written as
+// "Type.Method".
+//
+// Since paths may themselves contain colons, the path is split at the first
+// colon that is followed by a valid coda and preceded by the name of an
+// existing file, or failing that, the first colon followed by a valid coda.
+// A drive letter such as "C:" is never taken as the start of a coda.
+//
+// Relative paths are interpreted relative to the working directory of repo,
+// and the path must lie within its working tree.
+func ParseSpec(repo Repo, s string) (Spec, error) {
+	exists := func(p string) bool {
+		name, err := RepoPath(repo, p)
+		if err != nil {
+			return false
+		}
+		_, err = fs.Stat(repo, name)
+		return err == nil
+	}
+	if exists(s) {
+		return newSpec(repo, s)
+	}
+	split := -1
+	for i := 0; i < len(s); i++ {
+		if s[i] != ':' || isDriveColon(s, i) || !isCoda(s[i+1:]) {
+			continue
+		}
+		if split < 0 {
+			split = i
+		}
+		if exists(s[:i]) {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		return newSpec(repo, s)
+	}
+
+	spec, err := newSpec(repo, s[:split])
+	if err != nil {
+		return spec, err
+	}
+	coda := s[split+1:]
+	switch {
+	case strings.HasPrefix(coda, "@"):
+		expr, n := coda[1:], 1
+		if m := occurrence.FindStringSubmatch(expr); m != nil {
+			expr = m[1]
+			n, _ = strconv.Atoi(m[2])
+		}
+		re, err := regexp.Compile("(?msU)" + expr)
+		if err != nil {
+			return spec, fmt.Errorf("invalid regexp: %w", err)
+		}
+		lr, err := grepFile(repo, spec.Path, re, n)
+		spec.Range = lr
+		return spec, err
+
+	case strings.HasPrefix(coda, "#"):
+		lr, err := findDecl(repo, spec.Path, coda[1:])
+		spec.Range = lr
+		return spec, err
+	}
+
+	ends := strings.SplitN(coda, "-", 2)
+	spec.Lo, spec.LoCol, err = parsePos(ends[0])
+	if err == nil && len(ends) == 2 {
+		spec.Hi, spec.HiCol, err = parsePos(ends[1])
+	}
+	return spec, err
+}
+
+// newSpec returns a Spec for the file at path, which must be in repo.
+func newSpec(repo Repo, path string) (Spec, error) {
+	name, err := RepoPath(repo, path)
+	if err != nil {
+		return Spec{Local: path}, err
+	}
+	return Spec{Path: name, Local: path}, nil
+}
+
+// RepoPath returns path relative to the root of repo, as a slash-separated
+// name. The root itself is ".".
+func RepoPath(repo Repo, path string) (string, error) {
+	root, err := repo.Root()
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		wd, err := repo.WorkDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(wd, path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path %q is outside the repository", path)
+	}
+	return rel, nil
+}
+
+var (
+	lineCoda   = regexp.MustCompile(`^\d+(\.\d+)?(-\d+(\.\d+)?)?$`)
+	occurrence = regexp.MustCompile(`^(.+)#(\d+)$`)
+)
+
+// isCoda reports whether s is syntactically a location coda.
+func isCoda(s string) bool {
+	return lineCoda.MatchString(s) || len(s) > 1 && (s[0] == '@' || s[0] == '#')
+}
+
+// isDriveColon reports whether the colon at offset i of s follows a Windows
+// drive letter, as in "C:\src" or "C:/src".
+func isDriveColon(s string, i int) bool {
+	if i != 1 || !('a' <= s[0] && s[0] <= 'z' || 'A' <= s[0] && s[0] <= 'Z') {
+		return false
+	}
+	return len(s) == 2 || s[2] == '\\' || s[2] == '/'
+}
+
+// parsePos parses a line number with an optional column, "dd" or "dd.cc".
+func parsePos(s string) (line, col int, err error) {
+	ln, cs := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		ln, cs = s[:i], s[i+1:]
+	}
+	line, err = strconv.Atoi(ln)
+	if err == nil && cs != "" {
+		col, err = strconv.Atoi(cs)
+	}
+	return line, col, err
+}
+
+// grepFile returns the lines spanned by the nth match of re in the named file.
+func grepFile(repo Repo, name string, re *regexp.Regexp, n int) (Range, error) {
+	data, err := fs.ReadFile(repo, name)
+	if err != nil {
+		return Range{}, err
+	}
+	ms := re.FindAllIndex(data, n)
+	if len(ms) == 0 {
+		return Range{}, fmt.Errorf("no match for regexp %v", re)
+	} else if len(ms) < n {
+		return Range{}, fmt.Errorf("only %d matches for regexp %v", len(ms), re)
+	}
+	m := ms[n-1]
+	lo := bytes.Count(data[:m[0]], []byte("\n")) + 1
+	hi := lo + bytes.Count(data[m[0]:m[1]], []byte("\n"))
+	return Range{Lo: lo, Hi: hi}, nil
+}
+
+// findDecl returns the lines spanned by the named top-level declaration in
+// the Go source file at path. A method may be named as "Type.Method".
+func findDecl(repo Repo, path, name string) (Range, error) {
+	if !strings.HasSuffix(path, ".go") {
+		return Range{}, errors.New("declarations can only be found in Go source files")
+	}
+	src, err := fs.ReadFile(repo, path)
+	if err != nil {
+		return Range{}, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return Range{}, err
+	}
+	recv, base := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		recv, base = name[:i], name[i+1:]
+		recv = strings.Trim(recv, "(*)")
+	}
+
+	var found []ast.Node
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Name.Name == base && receiverName(d) == recv {
+				found = append(found, d)
+			}
+		case *ast.GenDecl:
+			if recv != "" {
+				continue
+			}
+			for _, spec := range d.Specs {
+				var node ast.Node = spec
+				if !d.Lparen.IsValid() {
+					node = d // include the keyword of an ungrouped declaration
+				}
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.Name == base {
+						found = append(found, node)
+					}
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						if id.Name == base {
+							found = append(found, node)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// If a bare name does not match a function or other declaration, accept
+	// a method of that name, provided it is unique.
+	if len(found) == 0 && recv == "" {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && d.Recv != nil && d.Name.Name == base {
+				found = append(found, d)
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Range{}, fmt.Errorf("no declaration of %q in %s", name, path)
+	case 1:
+		return Range{
+			Lo: fset.Position(found[0].Pos()).Line,
+			Hi: fset.Position(found[0].End()).Line,
+		}, nil
+	default:
+		return Range{}, fmt.Errorf("%d declarations of %q in %s", len(found), name, path)
+	}
+}
+
+// receiverName returns the base type name of the receiver of d, or "" if d
+// is not a method.
+func receiverName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return ""
+	}
+	t := d.Recv.List[0].Type
+	for {
+		switch x := t.(type) {
+		case *ast.StarExpr:
+			t = x.X
+		case *ast.IndexExpr: // generic receiver, T[P]
+			t = x.X
+		case *ast.ParenExpr:
+			t = x.X
+		case *ast.Ident:
+			return x.Name
+		default:
+			return ""
+		}
+	}
+}
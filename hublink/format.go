@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/creachadair/misctools/hublink/forgelink"
+)
+
+var (
+	outFormat string // output format
+	linkText  string // template for link text
+	doSnippet bool   // include the linked lines
+)
+
+// An output is a link to be printed, with the values available to the link
+// text template.
+type output struct {
+	URL   string
+	Label string // a description of the target, e.g., "main.go:10-20"
+	Ref   string // the branch or commit linked to
+	Repo  string // the repository name
+
+	Path  string // for links to files, the path relative to the repository root
+	Lines string // for links to files, e.g., "10-20", or ""
+
+	// The content of the linked lines, if requested.
+	Snippet string
+}
+
+// defaultText gives the default link text template for each format.
+var defaultText = map[string]string{
+	"markdown": "`{loc}`",
+	"html":     "<code>{loc}</code>",
+	"org":      "~{loc}~",
+	"rst":      "{loc}",
+}
+
+// format renders o in the selected output format.
+func (o *output) format() (string, error) {
+	f := outFormat
+	if f == "md" {
+		f = "markdown"
+	}
+	if f == "" || f == "url" {
+		if o.Snippet != "" {
+			return o.URL + "\n" + o.Snippet, nil
+		}
+		return o.URL, nil
+	}
+	tmpl := linkText
+	if tmpl == "" {
+		tmpl = defaultText[f]
+	}
+
+	esc := func(s string) string { return s }
+	if f == "html" {
+		esc = html.EscapeString
+	}
+	text := strings.NewReplacer(
+		"{loc}", esc(o.Label),
+		"{path}", esc(o.Path),
+		"{lines}", esc(o.Lines),
+		"{ref}", esc(o.Ref),
+		"{repo}", esc(o.Repo),
+		"{url}", esc(o.URL),
+	).Replace(tmpl)
+
+	var buf strings.Builder
+	lang := snippetLang(o.Path)
+	switch f {
+	case "markdown":
+		fmt.Fprintf(&buf, "[%s](%s)", text, o.URL)
+		if o.Snippet != "" {
+			fence := "```"
+			for strings.Contains(o.Snippet, fence) {
+				fence += "`"
+			}
+			fmt.Fprintf(&buf, "\n\n%s%s\n%s%s", fence, lang, o.Snippet, fence)
+		}
+	case "html":
+		fmt.Fprintf(&buf, `<a href="%s">%s</a>`, html.EscapeString(o.URL), text)
+		if o.Snippet != "" {
+			fmt.Fprintf(&buf, "\n<pre><code>%s</code></pre>", html.EscapeString(o.Snippet))
+		}
+	case "org":
+		fmt.Fprintf(&buf, "[[%s][%s]]", o.URL, text)
+		if o.Snippet != "" {
+			fmt.Fprintf(&buf, "\n\n#+begin_src %s\n%s#+end_src", lang, o.Snippet)
+		}
+	case "rst":
+		fmt.Fprintf(&buf, "`%s <%s>`_", text, o.URL)
+		if o.Snippet != "" {
+			fmt.Fprintf(&buf, "\n\n.. code-block:: %s\n\n", lang)
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(o.Snippet, "\n"), "\n") {
+				fmt.Fprintf(&buf, "   %s", line)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown output format %q", outFormat)
+	}
+	return buf.String(), nil
+}
+
+// snippet returns lines lr of path (relative to the repository root) at ref,
+// or from the working tree if that fails. It returns "" if lr does not
+// specify any lines.
+func snippet(ref, real, path string, lr forgelink.Range) string {
+	if lr.Lo <= 0 {
+		return ""
+	}
+	data, err := gitRaw("show", ref+":"+real)
+	if err != nil {
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+	}
+	lines := splitLines(data)
+	lo, hi := lr.Lo, lr.Hi
+	if hi < lo {
+		hi = lo
+	}
+	if lo > len(lines) {
+		return ""
+	} else if hi > len(lines) {
+		hi = len(lines)
+	}
+	return strings.Join(lines[lo-1:hi], "\n") + "\n"
+}
+
+// snippetLang returns a language tag for code blocks based on the file name.
+func snippetLang(name string) string {
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	switch ext {
+	case "py":
+		return "python"
+	case "js":
+		return "javascript"
+	case "ts":
+		return "typescript"
+	case "rs":
+		return "rust"
+	case "h":
+		return "c"
+	case "cc", "cxx", "hpp":
+		return "cpp"
+	case "sh", "bash":
+		return "shell"
+	case "yml":
+		return "yaml"
+	case "md":
+		return "markdown"
+	case "proto":
+		return "protobuf"
+	}
+	return ext
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/misctools/hublink/forgelink"
+)
+
+// A grepHit is a single match reported by git grep.
+type grepHit struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+
+	out *output
+}
+
+func addFlag(flag string, args []string) []string {
+	for _, arg := range args {
+		if arg == flag {
+			return args
+		}
+	}
+	return append([]string{flag}, args...)
+}
+
+func hasFlag(args []string, flags ...string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, flag := range flags {
+			if arg == flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func runGrepFile(env *command.Env, args []string) error {
+	// Our own flags must precede the flags for git grep.
+	var all, asJSON bool
+	for len(args) > 0 {
+		if args[0] == "-all" || args[0] == "--all" {
+			all = true
+		} else if args[0] == "-json" || args[0] == "--json" {
+			asJSON = true
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+
+	gargs := addFlag("-z", addFlag("-n", args))
+	if !hasFlag(args, "--untracked", "--no-recurse-submodules") {
+		gargs = addFlag("--recurse-submodules", gargs)
+	}
+	out, err := gitRaw("grep", gargs...)
+	if err != nil {
+		return fmt.Errorf("no matches: %w", err)
+	}
+	hits, err := parseGrepHits(out)
+	if err != nil {
+		return err
+	}
+
+	if !all && !asJSON && len(hits) != 1 {
+		if !isTerminal(os.Stdout) {
+			for _, h := range hits {
+				fmt.Printf("%s:%d:%s\n", h.Path, h.Line, h.Text)
+			}
+			return fmt.Errorf("found %d matches", len(hits))
+		}
+		h, err := chooseHit(hits)
+		if err != nil {
+			return err
+		}
+		hits = []*grepHit{h}
+	}
+
+	var gl grepLinker
+	for _, h := range hits {
+		if err := gl.link(h); err != nil {
+			return fmt.Errorf("%s:%d: %v", h.Path, h.Line, err)
+		}
+	}
+	// Output not written by printAndOpen is copied here, if requested.
+	w := io.Writer(os.Stdout)
+	if doCopy {
+		w = io.MultiWriter(os.Stdout, &copyBuf)
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false) // keep & in URLs and <, > in matched text
+		return enc.Encode(hits)
+	}
+	for _, h := range hits {
+		if all && (outFormat == "" || outFormat == "url") && !doSnippet {
+			fmt.Fprintf(w, "%s\t%s\n", h.URL, strings.TrimSpace(h.Text))
+			if doBrowse {
+				if err := openURL(h.URL); err != nil {
+					return err
+				}
+			}
+		} else if err := printAndOpen(h.out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseGrepHits parses the output of git grep -n -z.
+func parseGrepHits(out []byte) ([]*grepHit, error) {
+	var hits []*grepHit
+	for _, line := range bytes.Split(bytes.TrimSuffix(out, []byte("\n")), []byte("\n")) {
+		parts := bytes.SplitN(line, []byte("\x00"), 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected grep output: %q", line)
+		}
+		n, err := strconv.Atoi(string(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid line number in grep output: %q", line)
+		}
+		hits = append(hits, &grepHit{Path: string(parts[0]), Line: n, Text: string(parts[2])})
+	}
+	return hits, nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// chooseHit prompts the user to select one of hits.
+func chooseHit(hits []*grepHit) (*grepHit, error) {
+	for i, h := range hits {
+		fmt.Fprintf(os.Stderr, "%3d) %s:%d: %s\n", i+1, h.Path, h.Line, strings.TrimSpace(h.Text))
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "Choose [1-%d]: ", len(hits))
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("no match selected")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == "q" {
+			return nil, errors.New("no match selected")
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(hits) {
+			return hits[n-1], nil
+		}
+	}
+}
+
+// A grepLinker generates links for grep hits, each resolved against the
+// repository that contains it, which may be a submodule.
+type grepLinker struct {
+	repos map[string]*linkRepo // by repository root
+}
+
+type linkRepo struct {
+	lk     *forgelink.Linker
+	repo   *forgeRepo
+	target string
+}
+
+// link populates the URL of h.
+func (g *grepLinker) link(h *grepHit) error {
+	abs, err := filepath.Abs(h.Path)
+	if err != nil {
+		return err
+	}
+	root, err := git("-C", filepath.Dir(abs), "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	if g.repos == nil {
+		g.repos = make(map[string]*linkRepo)
+	}
+	lr, ok := g.repos[root]
+	if !ok {
+		lr = new(linkRepo)
+		err := inDir(root, func() error {
+			// The branch requested is for the superproject, and is unlikely
+			// to be meaningful in a submodule.
+			branch := useBranch
+			if isSubmodule() {
+				branch = ""
+			}
+			var err error
+			lr.lk, lr.repo, err = newLinker(branch)
+			if err != nil {
+				return err
+			}
+			lr.target, err = lr.lk.Target()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		g.repos[root] = lr
+	}
+	return inDir(root, func() error {
+		o, err := linkFile(lr.lk, lr.repo, lr.target, abs+":"+strconv.Itoa(h.Line))
+		if err != nil {
+			return err
+		}
+		h.URL, h.out = o.URL, o
+		return nil
+	})
+}
+
+// isSubmodule reports whether the current directory is in a submodule.
+func isSubmodule() bool {
+	super, err := git("rev-parse", "--show-superproject-working-tree")
+	return err == nil && super != ""
+}
+
+// inDir calls f with the working directory set to dir, then restores it.
+// Since this tool runs git in the working directory, this is how links are
+// resolved in other repositories, such as submodules.
+func inDir(dir string, f func() error) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+	return f()
+}
@@ -1,19 +1,23 @@
-// Program hublink generates URLs to files stored in GitHub.
+// Program hublink generates URLs to files stored in GitHub and other forges.
+//
+// The type of forge is detected from the host of the remote URL. Well-known
+// public hosts (github.com, gitlab.com, bitbucket.org, gitea.com, codeberg.org,
+// and git.sr.ht) are recognized automatically; self-hosted forges can be
+// listed in the config file (see config.go for the format).
 package main
 
 import (
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/creachadair/command"
+	"github.com/creachadair/misctools/hublink/forgelink"
 )
 
 var (
@@ -21,21 +25,34 @@ var (
 	useHash   bool   // use the commit hash rather than the branch name
 	doBrowse  bool   // open in the browser
 	doRaw     bool   // link to raw file content
-)
-
-const (
-	githubBase    = "https://github.com/"
-	githubRawBase = "https://raw.githubusercontent.com/"
+	useRemote string // default: the remote tracked by the current branch
 )
 
 func main() {
 	env := (&command.C{
 		Name:  filepath.Base(os.Args[0]),
 		Usage: "<command> [arguments]",
-		Help:  "A command-line to link to objects in GitHub",
+		Help: `A command-line to link to objects in GitHub and other forges.
+
+Self-hosted forges may be listed in the config file, one per line:
+
+  forge <host> <type> [<base-url>]
+
+where type is github, gitlab, bitbucket, gitea, forgejo, or sourcehut.
+The commands used by -open and -copy may also be set:
+
+  browser <command> [<args>...]
+  clipboard <command> [<args>...]
+
+By default, -open uses $BROWSER, or a platform tool (open, xdg-open, or
+wslview), and -copy uses pbcopy, wl-copy, xclip, xsel, or clip.
+
+The config file is read from $HUBLINK_CONFIG if set, otherwise from
+hublink/config in the user configuration directory.`,
 
 		SetFlags: func(env *command.Env, fs *flag.FlagSet) {
 			fs.BoolVar(&doBrowse, "open", false, "Open link in browser")
+			fs.BoolVar(&doCopy, "copy", false, "Copy output to the clipboard")
 			setStdFlags(fs)
 			for _, cmd := range env.Command.Commands {
 				setStdFlags(&cmd.Flags)
@@ -48,226 +65,227 @@ func main() {
 			{
 				Name: "file",
 				Usage: `
-<path>       -- link to entire file
-<path>:LINE  -- link to a single specific line
-<path>:LO-HI -- link to a range of lines (LO < HI)
-<path>:@RE   -- link to the first match of this regexp (RE2)
+<path>             -- link to entire file
+<path>:LINE        -- link to a single specific line
+<path>:LO-HI       -- link to a range of lines (LO < HI)
+<path>:LO.C-HI.C   -- link to a range of lines and columns
+<path>:@RE         -- link to the first match of this regexp (RE2)
+<path>:@RE#N       -- link to the Nth match of this regexp
+<path>:#NAME       -- link to a Go declaration (function, Type.Method, ...)
 `,
 				Help: `Generate a link to the specified repository files.
 
 By default, a link is generated for the current branch.
-The repository name is derived from the first remote.`,
+
+With -check, hublink verifies that the branch or commit exists on the remote,
+and that the linked lines match the working tree. If the branch has not been
+pushed, the link falls back to the nearest pushed commit; if the lines have
+moved, the line numbers are adjusted. Discrepancies are reported as warnings.
+The repository name is derived from the remote given by -remote, or if that
+is not set, the remote tracked by the current branch, or "origin".`,
 
 				Run: runLinkFile,
 			},
 			{
 				Name:  "grep",
-				Usage: "[git-grep-flags] <pattern> [<path>...]",
-				Help:  "Generate a link to a git grep match.",
+				Usage: "[-all] [-json] [git-grep-flags] <pattern> [<path>...]",
+				Help: `Generate a link to a git grep match.
+
+If there are multiple matches and the output is a terminal, you are prompted
+to choose one. With -all, a link is generated for each match, followed by the
+text of the matching line. With -json, the matches and their links are
+written as a JSON array. These flags must precede the git grep flags.
+
+Matches in submodules are linked via the remote of the submodule.`,
 
 				CustomFlags: true,
 				Run:         runGrepFile,
 			},
+			{
+				Name:  "commit",
+				Usage: "[<rev>]",
+				Help:  "Generate a link to a commit (default HEAD).",
+
+				Run: runCommit,
+			},
+			{
+				Name:  "compare",
+				Usage: "<base>..[<head>]",
+				Help: `Generate a link to a comparison of two revisions.
+
+If <head> is omitted, the current branch (or -b) is used.
+With -H, both revisions are resolved to commit hashes.`,
+
+				Run: runCompare,
+			},
+			{
+				Name:  "blame",
+				Usage: "<path>[:LINE|:LO-HI|:@RE|:#NAME] ...",
+				Help:  "Generate a link to the blame view of a file, at the given lines.",
+
+				Run: runBlame,
+			},
+			{
+				Name:  "log",
+				Usage: "[<path>...]",
+				Help:  "Generate a link to the history of a file or directory.",
+
+				Run: runLog,
+			},
+			{
+				Name: "pr",
+				Help: `Generate a link to the pull requests for the current branch (or -b).`,
+
+				Run: runPull,
+			},
+			{
+				Name:  "open",
+				Usage: "<url>",
+				Help: `Resolve a link to a file back to a local path and line.
+
+The repository of the link must match one of the local remotes, and its
+branch or commit must exist locally. The result is printed as path:line.
+With -show, the linked lines are printed as of the linked revision.`,
+
+				SetFlags: setOpenFlags,
+				Run:      runOpen,
+			},
 			command.HelpCommand(nil),
 		},
 	}).NewEnv(nil)
 	command.RunOrFail(env, os.Args[1:])
-}
-
-func addFlag(flag string, args []string) []string {
-	for _, arg := range args {
-		if arg == flag {
-			return args
+	if doCopy {
+		if err := copyOutput(); err != nil {
+			log.Fatalf("Copying to clipboard: %v", err)
 		}
 	}
-	return append([]string{flag}, args...)
-}
-
-func runGrepFile(env *command.Env, args []string) error {
-	out, err := git("grep", addFlag("-n", args)...)
-	if err != nil {
-		return fmt.Errorf("no matches: %w", err)
-	}
-	hits := strings.Split(strings.TrimSpace(out), "\n")
-	if len(hits) != 1 {
-		fmt.Println(out)
-		return fmt.Errorf("found %d matches", len(hits))
-	}
-	parts := strings.SplitN(hits[0], ":", 3)
-	target := strings.Join(parts[:2], ":")
-	return runLinkFile(env, []string{target})
 }
 
 func runLinkFile(env *command.Env, args []string) error {
 	if len(args) == 0 {
 		return errors.New("no paths specified")
 	}
-	repo, dir, err := repoNameRoot()
+	lk, repo, err := newLinker(useBranch)
 	if err != nil {
 		return err
 	}
-	target, err := resolveBranch(useBranch)
+	target, err := lk.Target()
 	if err != nil {
 		return fmt.Errorf("resolving branch: %v", err)
 	}
 
 	for _, raw := range args {
-		path, lo, hi, err := parseFile(raw)
+		link, err := linkFile(lk, repo, target, raw)
 		if err != nil {
-			return fmt.Errorf("invalid file spec: %v", err)
+			return err
 		}
-		real, err := fixPath(dir, path)
-		if err != nil {
-			return fmt.Errorf("invalid path: %v", err)
-		}
-
-		var buf bytes.Buffer
-		if doRaw {
-			buf.WriteString(githubRawBase)
-			buf.WriteString(repo)
-			fmt.Fprintf(&buf, "/%s/", target)
-			buf.WriteString(real)
-		} else {
-			buf.WriteString(githubBase)
-			buf.WriteString(repo)
-			fmt.Fprintf(&buf, "/%s/%s/", gitObjectType(real), target)
-			buf.WriteString(real)
-
-			if lo > 0 {
-				fmt.Fprintf(&buf, "#L%d", lo)
-				if hi > lo {
-					fmt.Fprintf(&buf, "-L%d", hi)
-				}
-			}
-		}
-		if err := printAndOpen(buf.String()); err != nil {
-			return nil
+		if err := printAndOpen(link); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func currentBranch() (string, error) { return git("branch", "--show-current") }
-
-func resolveBranch(branch string) (string, error) {
-	if branch == "" {
-		cur, err := currentBranch()
-		if err != nil {
-			return "", fmt.Errorf("finding current branch: %v", err)
-		}
-		branch = cur
+// linkFile returns a link to the file spec raw at target in repo.
+func linkFile(lk *forgelink.Linker, repo *forgeRepo, target, raw string) (*output, error) {
+	spec, err := forgelink.ParseSpec(lk.Repo, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file spec: %v", err)
+	}
+	if doCheck {
+		target, spec.Range = repo.checkLink(target, spec.Local, spec.Path, spec.Range)
 	}
-	if useHash {
-		return git("rev-parse", branch)
+	link := lk.SpecLink(repo.Remote, target, spec)
+	if doRaw {
+		link.Raw, link.Range = true, forgelink.Range{}
 	}
-	return branch, nil
+	return fileOutput(repo, link.Ref, link.Path, spec.Local, link.Range, link.URL()), nil
 }
 
-func firstRemote() (string, error) {
-	rems, err := git("remote", "show", "-n")
-	if err != nil {
-		return "", fmt.Errorf("listing remotes: %v", err)
+// fileOutput returns an output for a link to lines lr of path, whose name
+// relative to the repository root is real.
+func fileOutput(repo *forgeRepo, ref, real, path string, lr forgelink.Range, url string) *output {
+	o := &output{URL: url, Label: real, Ref: ref, Repo: repo.Name, Path: real}
+	if o.Label == "" {
+		o.Label = repo.Name
+	}
+	if lr.Lo > 0 {
+		o.Lines = span(lr.Lo, lr.Hi)
+		o.Label += ":" + o.Lines
+	}
+	if doSnippet {
+		o.Snippet = snippet(ref, real, path, lr)
 	}
-	return strings.SplitN(rems, "\n", 2)[0], nil
+	return o
+}
+
+// A forgeRepo is the hosted repository of a local remote.
+type forgeRepo struct {
+	*forgelink.Remote
 }
 
-func repoNameRoot() (name, dir string, _ error) {
-	dir, err := git("rev-parse", "--show-toplevel")
+// newLinker returns a linker for the repository containing the working
+// directory, using the remote selected by -remote, and branch (or the current
+// branch if branch == ""). It also returns the hosted repository to link to.
+func newLinker(branch string) (*forgelink.Linker, *forgeRepo, error) {
+	cfg, err := loadConfig()
 	if err != nil {
-		return "", "", fmt.Errorf("getting repository root: %v", err)
+		return nil, nil, fmt.Errorf("loading config: %v", err)
 	}
-	remote, err := firstRemote()
+	g, err := forgelink.NewGit("")
 	if err != nil {
-		return "", "", err
+		return nil, nil, err
 	}
-	url, err := git("remote", "get-url", remote)
-	if err != nil {
-		return "", "", fmt.Errorf("getting remote URL: %v", err)
+	lk := &forgelink.Linker{
+		Repo:    g,
+		Hosts:   cfg.Forges,
+		Remote:  useRemote,
+		Branch:  branch,
+		UseHash: useHash,
 	}
-	if strings.HasPrefix(url, "git@") {
-		return strings.TrimSuffix(strings.TrimPrefix(url, "git@github.com:"), ".git"), dir, nil
+	r, err := lk.FindRemote()
+	if errors.Is(err, forgelink.ErrUnknownForge) {
+		return nil, nil, fmt.Errorf("%v (add it to %s)", err, configPath())
+	} else if err != nil {
+		return nil, nil, err
 	}
-	return strings.TrimSuffix(strings.TrimPrefix(url, githubBase), ".git"), dir, nil
+	return lk, &forgeRepo{Remote: r}, nil
 }
 
 func git(cmd string, args ...string) (string, error) {
+	out, err := gitRaw(cmd, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+// gitRaw is as git, but returns the output unmodified.
+func gitRaw(cmd string, args ...string) ([]byte, error) {
 	out, err := exec.Command("git", append([]string{cmd}, args...)...).Output()
 	if err != nil {
 		var ex *exec.ExitError
 		if errors.As(err, &ex) {
-			return "", errors.New(strings.SplitN(string(ex.Stderr), "\n", 2)[0])
+			return nil, errors.New(strings.SplitN(string(ex.Stderr), "\n", 2)[0])
 		}
-		return "", err
+		return nil, err
 	}
-	return strings.TrimSpace(string(out)), nil
+	return out, nil
 }
 
-func fixPath(dir, path string) (string, error) {
-	abs, err := filepath.Abs(path)
-	if err != nil {
-		return "", err
-	}
-	return filepath.Rel(dir, abs)
-}
-
-// parseFile parses a file path with an optional coda specifying a location
-// within the file. The coda may have the following forms
-//
-//   :dd
-//   :dd-dd
-//   :@re
-//
-// where "dd" represents decimal digits and "re" represents an RE2 regular
-// expression. The first two forms indicate a single line or range of lines,
-// the third indicates the line or range corresponding to the first match of
-// the given regular expression in the file content.
-func parseFile(s string) (path string, lo, hi int, err error) {
-	if i := strings.LastIndex(s, ":"); i < 0 {
-		return s, 0, 0, nil
-	} else {
-		path, s = s[:i], s[i+1:]
-	}
-	if strings.HasPrefix(s, "@") {
-		re, err := regexp.Compile("(?msU)" + s[1:])
-		if err != nil {
-			return path, 0, 0, fmt.Errorf("invalid regexp: %w", err)
-		}
-		return grepFile(path, re)
-	}
-	span := strings.SplitN(s, "-", 2)
-	lo, err = strconv.Atoi(span[0])
-	if err == nil && len(span) == 2 {
-		hi, err = strconv.Atoi(span[1])
-	}
-	return path, lo, hi, err
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
 }
 
-func grepFile(path string, re *regexp.Regexp) (_ string, lo, hi int, err error) {
-	data, err := os.ReadFile(path)
+func printAndOpen(o *output) error {
+	s, err := o.format()
 	if err != nil {
-		return path, 0, 0, err
-	}
-	m := re.FindIndex(data)
-	if m == nil {
-		return path, 0, 0, fmt.Errorf("no match for regexp %v", re)
-	}
-	lo = bytes.Count(data[:m[0]], []byte("\n")) + 1
-	hi = lo + bytes.Count(data[m[0]:m[1]], []byte("\n"))
-	return path, lo, hi, nil
-}
-
-func gitObjectType(path string) string {
-	fi, err := os.Stat(path)
-	if err == nil && fi.IsDir() {
-		return "tree"
+		return err
 	}
-	return "blob"
-}
-
-func printAndOpen(s string) error {
 	fmt.Println(s)
+	if doCopy {
+		fmt.Fprintln(&copyBuf, s)
+	}
 	if doBrowse {
-		return exec.Command("open", s).Run()
+		return openURL(o.URL)
 	}
 	return nil
 }
@@ -276,4 +294,10 @@ func setStdFlags(fs *flag.FlagSet) {
 	fs.StringVar(&useBranch, "b", "", "Link to this branch (default is current)")
 	fs.BoolVar(&useHash, "H", false, "Use commit hash instead of branch name")
 	fs.BoolVar(&doRaw, "raw", false, "Link to raw file content (ignores offsets)")
+	fs.BoolVar(&doCheck, "check", false, "Check that the link matches what is pushed to the remote")
+	fs.BoolVar(&useLsRemote, "ls-remote", false, "With -check, query the remote instead of remote-tracking branches")
+	fs.StringVar(&useRemote, "remote", "", "Link to this remote (default is the one tracked by the current branch)")
+	fs.StringVar(&outFormat, "format", "url", "Output format (url, markdown, html, org, rst)")
+	fs.StringVar(&linkText, "text", "", "Template for link text: {loc}, {path}, {lines}, {ref}, {repo}, {url}")
+	fs.BoolVar(&doSnippet, "snippet", false, "Include the linked lines as a code block")
 }
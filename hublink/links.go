@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/misctools/hublink/forgelink"
+)
+
+func runCommit(env *command.Env, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: commit [<rev>]")
+	}
+	rev := "HEAD"
+	if len(args) == 1 {
+		rev = args[0]
+	}
+	lk, repo, err := newLinker(useBranch)
+	if err != nil {
+		return err
+	}
+	hash, err := lk.Repo.ResolveCommit(rev)
+	if err != nil {
+		return fmt.Errorf("resolving %q: %v", rev, err)
+	}
+	if doCheck {
+		if _, ok := repo.pushedCommit(hash); !ok {
+			warnf("commit %.12s is not on remote %q", hash, repo.Local)
+		}
+	}
+	link, err := repo.CommitURL(hash)
+	if err != nil {
+		return err
+	}
+	return printAndOpen(&output{URL: link, Label: hash[:12], Ref: hash, Repo: repo.Name})
+}
+
+func runCompare(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: compare <base>..[<head>]")
+	}
+	from, to, ok := cutRange(args[0])
+	if !ok || from == "" {
+		return fmt.Errorf("invalid range %q", args[0])
+	}
+	if to == "" {
+		to = useBranch
+	}
+	lk, repo, err := newLinker(to)
+	if err != nil {
+		return err
+	}
+	if to, err = lk.Target(); err != nil {
+		return fmt.Errorf("resolving branch: %v", err)
+	}
+	if useHash {
+		if from, err = lk.Repo.ResolveCommit(from); err != nil {
+			return fmt.Errorf("resolving %q: %v", args[0], err)
+		}
+	}
+	link, err := repo.CompareURL(from, to)
+	if err != nil {
+		return err
+	}
+	return printAndOpen(&output{URL: link, Label: from + "..." + to, Ref: to, Repo: repo.Name})
+}
+
+// cutRange splits a revision range "A..B" or "A...B" into its endpoints.
+func cutRange(s string) (from, to string, ok bool) {
+	i := strings.Index(s, "..")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], strings.TrimPrefix(s[i+2:], "."), true
+}
+
+func runBlame(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no paths specified")
+	}
+	lk, repo, err := newLinker(useBranch)
+	if err != nil {
+		return err
+	}
+	target, err := lk.Target()
+	if err != nil {
+		return fmt.Errorf("resolving branch: %v", err)
+	}
+	for _, raw := range args {
+		spec, err := forgelink.ParseSpec(lk.Repo, raw)
+		if err != nil {
+			return fmt.Errorf("invalid file spec: %v", err)
+		}
+		ref, lr := target, spec.Range
+		if doCheck {
+			ref, lr = repo.checkLink(target, spec.Local, spec.Path, lr)
+		}
+		link, err := repo.BlameURL(ref, spec.Path, lr)
+		if err != nil {
+			return err
+		}
+		if err := printAndOpen(fileOutput(repo, ref, spec.Path, spec.Local, lr, link)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLog(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	lk, repo, err := newLinker(useBranch)
+	if err != nil {
+		return err
+	}
+	target, err := lk.Target()
+	if err != nil {
+		return fmt.Errorf("resolving branch: %v", err)
+	}
+	for _, path := range args {
+		real, err := forgelink.RepoPath(lk.Repo, path)
+		if err != nil {
+			return fmt.Errorf("invalid path: %v", err)
+		}
+		if real == "." {
+			real = ""
+		}
+		link, err := repo.HistoryURL(target, real)
+		if err != nil {
+			return err
+		}
+		label := real
+		if label == "" {
+			label = repo.Name
+		}
+		if err := printAndOpen(&output{URL: link, Label: label, Ref: target, Repo: repo.Name, Path: real}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runPull(env *command.Env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: pr")
+	}
+	lk, repo, err := newLinker(useBranch)
+	if err != nil {
+		return err
+	}
+	branch := useBranch
+	if branch == "" {
+		branch, err = lk.Repo.CurrentBranch()
+		if err != nil {
+			return fmt.Errorf("finding current branch: %v", err)
+		} else if branch == "" {
+			return errors.New("HEAD is not on a branch")
+		}
+	}
+	link, err := repo.PullURL(branch)
+	if err != nil {
+		return err
+	}
+	return printAndOpen(&output{URL: link, Label: branch, Ref: branch, Repo: repo.Name})
+}
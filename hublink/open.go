@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/misctools/hublink/forgelink"
+)
+
+var doShow bool // print the content of the linked lines
+
+func setOpenFlags(env *command.Env, fs *flag.FlagSet) {
+	fs.BoolVar(&doShow, "show", false, "Print the linked content at the linked revision")
+}
+
+// A location is a file and line range resolved from a forge URL.
+type location struct {
+	Remote string // the name of the matching remote
+	Commit string // the local commit hash of the linked ref
+	Path   string // relative to the repository root
+	Lo, Hi int    // linked lines, or 0
+}
+
+func runOpen(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: open <url>")
+	}
+	dir, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("getting repository root: %v", err)
+	}
+	loc, err := resolveURL(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Print the path relative to the working directory, for an editor.
+	path := filepath.Join(dir, filepath.FromSlash(loc.Path))
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil {
+			path = rel
+		}
+	}
+	if loc.Lo > 0 {
+		fmt.Printf("%s:%d\n", path, loc.Lo)
+	} else {
+		fmt.Println(path)
+	}
+
+	// Warn if the local file is not what the link refers to.
+	if _, err := git("-C", dir, "diff", "--quiet", loc.Commit, "--", loc.Path); err != nil {
+		fmt.Fprintf(os.Stderr, "Note: %s differs from the linked revision %.12s\n", loc.Path, loc.Commit)
+	}
+	if doShow {
+		return showLines(loc)
+	}
+	return nil
+}
+
+// showLines prints the linked lines of loc at its commit.
+func showLines(loc *location) error {
+	out, err := gitRaw("show", loc.Commit+":"+loc.Path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(out, []byte("\n"))
+	lo, hi := loc.Lo, loc.Hi
+	if lo <= 0 {
+		lo, hi = 1, len(lines)
+	} else if hi < lo {
+		hi = lo
+	}
+	for i := lo; i <= hi && i <= len(lines); i++ {
+		if _, err := os.Stdout.Write(lines[i-1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveURL maps a link to a file on a forge to a location in the local
+// repository. The repository of the link must match one of the local
+// remotes, and its ref must resolve to a local commit.
+func resolveURL(link string) (*location, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	out, err := git("remote")
+	if err != nil {
+		return nil, fmt.Errorf("listing remotes: %v", err)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %v", err)
+	}
+
+	for _, rem := range strings.Fields(out) {
+		rurl, err := git("remote", "get-url", rem)
+		if err != nil {
+			continue
+		}
+		ru, err := forgelink.ParseRemoteURL(rurl)
+		if err != nil {
+			continue
+		}
+		r, err := forgelink.FindRemote(cfg.Forges, ru)
+		if err != nil {
+			continue
+		}
+		repo := &forgeRepo{Remote: r}
+		base, err := url.Parse(repo.Base)
+		if err != nil || !sameHost(base, u) {
+			continue
+		}
+		prefix := strings.TrimSuffix(base.Path, "/") + "/" + repo.Name + "/"
+		if !strings.HasPrefix(u.Path, prefix) {
+			continue
+		}
+		loc, err := repo.matchFile(rem, strings.TrimPrefix(u.Path, prefix))
+		if err != nil {
+			return nil, err
+		}
+		loc.Lo, loc.Hi = parseAnchor(u.Fragment)
+		return loc, nil
+	}
+	return nil, fmt.Errorf("no remote matches %s", link)
+}
+
+// sameHost reports whether a and b refer to the same host and port, where an
+// omitted port is the default for the scheme.
+func sameHost(a, b *url.URL) bool {
+	port := func(u *url.URL) string {
+		if p := u.Port(); p != "" {
+			return p
+		} else if u.Scheme == "http" {
+			return "80"
+		}
+		return "443"
+	}
+	return strings.EqualFold(a.Hostname(), b.Hostname()) && port(a) == port(b)
+}
+
+// matchFile matches the path of a link to a file, relative to the
+// repository, against the forge's templates for files, directories, and
+// blame views.
+func (r *forgeRepo) matchFile(remote, tail string) (*location, error) {
+	segs := strings.Split(tail, "/")
+	for _, tmpl := range []string{r.Blob, r.Tree, r.Blame} {
+		pat := strings.TrimPrefix(tmpl, "{base}/{repo}/")
+		if pat == tmpl {
+			continue // not relative to the repository
+		}
+		if loc, ok := matchSegments(remote, strings.Split(pat, "/"), segs); ok {
+			return loc, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot resolve %q to a file in %s", tail, r.Name)
+}
+
+// matchSegments matches the path segments of a link against the segments of
+// a template. Since both refs and paths may contain slashes, {ref} is matched
+// against successively longer prefixes until one resolves to a local commit.
+func matchSegments(remote string, pat, segs []string) (*location, bool) {
+	for i, p := range pat {
+		switch p {
+		case "{kind}":
+			if len(segs) == 0 {
+				return nil, false
+			}
+			segs = segs[1:]
+		case "{ref}":
+			rest := pat[i+1:]
+			for n := 1; n <= len(segs); n++ {
+				tail, ok := matchLiterals(rest, segs[n:])
+				if !ok {
+					continue
+				}
+				commit, ok := resolveRef(remote, strings.Join(segs[:n], "/"))
+				if !ok {
+					continue
+				}
+				return &location{
+					Remote: remote,
+					Commit: commit,
+					Path:   strings.Join(tail, "/"),
+				}, true
+			}
+			return nil, false
+		default:
+			if len(segs) == 0 || segs[0] != p {
+				return nil, false
+			}
+			segs = segs[1:]
+		}
+	}
+	return nil, false
+}
+
+// matchLiterals matches the literal segments of pat before {path} against
+// segs, and returns the remaining segments.
+func matchLiterals(pat, segs []string) ([]string, bool) {
+	for _, p := range pat {
+		if p == "{path}" {
+			return segs, true
+		} else if len(segs) == 0 || segs[0] != p {
+			return nil, false
+		}
+		segs = segs[1:]
+	}
+	return segs, len(segs) == 0
+}
+
+// resolveRef resolves a ref named in a link to a local commit hash. A branch
+// name is resolved preferably via the remote-tracking branch.
+func resolveRef(remote, ref string) (string, bool) {
+	for _, cand := range []string{remote + "/" + ref, ref} {
+		if hash, err := git("rev-parse", "--verify", "--quiet", cand+"^{commit}"); err == nil && hash != "" {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+var (
+	anchorNums = regexp.MustCompile(`(\d+)(?:\D+(\d+))?`)
+	anchorCols = regexp.MustCompile(`(\d)C\d+`)
+)
+
+// parseAnchor parses a line or range anchor, such as "L10-L20" (GitHub),
+// "L10-20" (GitLab), or "lines-10:20" (Bitbucket). Columns, as in
+// "L10C5-L20C8", are ignored.
+func parseAnchor(frag string) (lo, hi int) {
+	m := anchorNums.FindStringSubmatch(anchorCols.ReplaceAllString(frag, "$1"))
+	if m == nil {
+		return 0, 0
+	}
+	lo, _ = strconv.Atoi(m[1])
+	hi, _ = strconv.Atoi(m[2])
+	return lo, hi
+}
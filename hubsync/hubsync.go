@@ -1,15 +1,41 @@
 // Program hubsync pulls changes from a remote to the default branch
 // of a local clone of a GitHub repository, then rebases local branches onto
 // that branch if they have a corresponding remote branch.
+//
+// With -n (-dry-run), hubsync prints the plan for the default branch and
+// each selected branch, without changing the repository.
+//
+// If rebasing a branch conflicts, the -on-conflict flag selects a policy:
+//
+//	abort   abort the rebase, skip the branch, and go on to the others
+//	stop    leave the rebase in progress for manual resolution
+//	stash   as abort, but rebase with --autostash so that local changes
+//	        do not prevent it
+//
+// With -push, updated branches are pushed with --force-with-lease, so that a
+// remote branch is not overwritten if it has moved since it was fetched. A
+// branch is also not pushed if its remote has commits that the local branch
+// lacks, for example ones a teammate pushed. Such branches are reported
+// separately.
+//
+// hubsync expects a clean working tree. With -autostash, local changes are
+// stashed before syncing and restored afterward; -stash-include selects
+// whether this includes untracked files (the default), ignored files too
+// ("all"), or only changes to tracked files ("tracked").
+//
+// Afterward, hubsync reports which branches succeeded, were skipped, or
+// conflicted, and switches back to the branch that was checked out.
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"bitbucket.org/creachadair/shell"
@@ -21,89 +47,285 @@ var (
 	branchPrefix = flag.String("prefix", "", "Select branches matching this prefix")
 	doForcePush  = flag.Bool("push", false, "Force push updated branches to remote")
 	doVerbose    = flag.Bool("v", false, "Verbose logging")
+	doDryRun     = flag.Bool("dry-run", false, "Print what would be done without changing anything")
+	onConflict   = flag.String("on-conflict", "abort", "What to do when a rebase conflicts (abort, stop, stash)")
+	doAutostash  = flag.Bool("autostash", false, "Stash local changes before syncing and restore them afterward")
+	stashInclude = flag.String("stash-include", "untracked", "With -autostash, which changed files to stash (tracked, untracked, all)")
 )
 
+func init() {
+	flag.BoolVar(doDryRun, "n", false, "Alias for -dry-run")
+}
+
 func main() {
 	flag.Parse()
 	if *useRemote == "" {
 		log.Fatal("You must specify a -remote name to use")
 	}
+	switch *onConflict {
+	case "abort", "stop", "stash":
+	default:
+		log.Fatalf("Invalid -on-conflict policy %q (want abort, stop, or stash)", *onConflict)
+	}
+	switch *stashInclude {
+	case "tracked", "untracked", "all":
+	default:
+		log.Fatalf("Invalid -stash-include policy %q (want tracked, untracked, or all)", *stashInclude)
+	}
+	if err := run(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
 
+// run performs the sync. Unlike a fatal error, returning from run allows the
+// original branch to be restored.
+func run() (err error) {
 	// Set working directory to the repository root.
 	root, err := repoRoot()
 	if err != nil {
-		log.Fatalf("Repository root: %v", err)
+		return fmt.Errorf("repository root: %w", err)
 	} else if err := os.Chdir(root); err != nil {
-		log.Fatalf("Chdir: %v", err)
+		return err
 	}
 
-	// Save the name of the current branch so we can go back.
+	// Save the name of the current branch so we can go back. If HEAD is
+	// detached, save the commit instead.
 	save, err := currentBranch()
 	if err != nil {
-		log.Fatalf("Current branch: %v", err)
+		return fmt.Errorf("current branch: %w", err)
+	} else if save == "" {
+		if save, err = git("rev-parse", "HEAD"); err != nil {
+			return fmt.Errorf("current commit: %w", err)
+		}
 	}
 
 	// Find the name of the default branch.
 	dbranch, err := defaultBranch(*defBranch, *useRemote)
 	if err != nil {
-		log.Fatalf("Default branch: %v", err)
+		return fmt.Errorf("default branch: %w", err)
 	}
-	defer func() {
-		_, err := git("checkout", save)
-		if err != nil {
-			log.Fatalf("Switching to %q: %v", save, err)
-		}
-		log.Printf("Switched back to %q", save)
-	}()
 
 	// List local branches that track corresponding remote branches.
 	rem, err := listBranchInfo(*branchPrefix+"*", dbranch, *useRemote)
 	if err != nil {
-		log.Fatalf("Listing branches: %v", err)
+		return fmt.Errorf("listing branches: %w", err)
+	}
+	if *doDryRun {
+		if err := printPlan(dbranch, *useRemote, rem); err != nil {
+			return fmt.Errorf("planning: %w", err)
+		}
+		return nil
+	}
+
+	// Set aside local changes, if requested, so that they do not interfere
+	// with checking out and rebasing other branches.
+	var stash string // the commit hash of our stash, if any
+	if dirty, err := hasChanges(*stashInclude); err != nil {
+		return fmt.Errorf("checking for local changes: %w", err)
+	} else if dirty && *doAutostash {
+		stash, err = stashChanges(*stashInclude, "hubsync autostash on "+save)
+		if err != nil {
+			return fmt.Errorf("stashing local changes: %w", err)
+		}
+		if stash == "" {
+			log.Print("No local changes that can be stashed")
+		} else {
+			log.Printf("Stashed local changes (%.12s)", stash)
+		}
+	} else if dirty, _ := hasChanges("tracked"); dirty {
+		log.Print("Warning: the working tree has local changes, which may prevent the sync (see -autostash)")
 	}
 
+	var stopped string // the branch whose rebase was left in progress
+	defer func() {
+		if stopped != "" {
+			log.Printf("Rebase of %q is in progress; resolve it, then run: git checkout %s", stopped, save)
+			if stash != "" {
+				log.Printf("Your local changes are stashed; afterward, run: git stash pop")
+			}
+			return
+		}
+		if _, cerr := git("checkout", save); cerr != nil {
+			log.Printf("Switching to %q: %v", save, cerr)
+			if stash != "" {
+				log.Printf("Your local changes are stashed as %.12s; restore them with git stash pop", stash)
+			}
+			if err == nil {
+				err = cerr
+			}
+			return
+		}
+		log.Printf("Switched back to %q", save)
+		if stash != "" {
+			if perr := popStash(stash); perr != nil {
+				log.Printf("Warning: %v", perr)
+				if err == nil {
+					err = perr
+				}
+				return
+			}
+			log.Print("Restored local changes")
+		}
+	}()
+
 	// Pull the latest content. Note we need to do this after checking branches,
 	// since it changes which branches follow the default.
 	log.Printf("Pulling default branch %q", dbranch)
 	if err := pullBranch(dbranch); err != nil {
-		log.Fatalf("Pull %q: %v", dbranch, err)
+		return fmt.Errorf("pull %q: %w", dbranch, err)
 	}
 
 	// Bail out if no branches need updating. But note we do this after pulling,
 	// so that we will pull the changes even if no updates are required.
 	if len(rem) == 0 {
 		log.Print("No branches require update")
-		return
+		return nil
 	}
 
 	// Rebase the local branches onto the default, and if requested and
 	// necessary, push the results back up to the remote.
+	var res results
 	for _, br := range rem {
+		if stopped != "" {
+			res.add(br.Name, skipped, "sync stopped at "+strconv.Quote(stopped))
+			continue
+		}
+		// Record where the remote branch is before we rewrite the local one.
+		// The push is conditioned on it, so that it will not discard commits
+		// pushed since. Commits the remote already has that are not in the
+		// local branch (e.g., fetched by the pull) must not be discarded either.
+		var lease string
+		var unseen int
+		if br.Remote {
+			tracking := *useRemote + "/" + br.Name
+			lease, _ = git("rev-parse", "--verify", "--quiet", "refs/remotes/"+tracking)
+			unseen, _ = countCommits("--cherry-pick", "--right-only", br.Name+"..."+tracking)
+		}
+
 		log.Printf("Rebasing %q onto %q", br.Name, dbranch)
-		if _, err := git("rebase", dbranch, br.Name); err != nil {
-			log.Fatalf("Rebase failed: %v", err)
+		if err := rebaseBranch(dbranch, br.Name); err != nil {
+			if !rebaseInProgress() {
+				res.add(br.Name, skipped, "rebase failed: "+err.Error())
+				continue
+			}
+			if *onConflict == "stop" {
+				log.Printf("- Conflict rebasing %q; stopping for manual resolution", br.Name)
+				res.add(br.Name, conflicted, "left for manual resolution")
+				stopped = br.Name
+				continue
+			}
+			if _, err := git("rebase", "--abort"); err != nil {
+				stopped = br.Name // we cannot safely go on
+				res.add(br.Name, conflicted, "rebase --abort failed: "+err.Error())
+				continue
+			}
+			log.Printf("- Conflict rebasing %q; aborted", br.Name)
+			res.add(br.Name, conflicted, "rebase aborted")
+			continue
 		}
 		if !*doForcePush || !br.Remote {
+			res.add(br.Name, succeeded, "rebased")
+			continue
+		}
+		if unseen > 0 {
+			log.Printf("- Not updating %q: %s/%s has %s not in the local branch",
+				br.Name, *useRemote, br.Name, plural(unseen, "commit"))
+			res.add(br.Name, remoteMoved, "merge the remote changes first")
 			continue
 		}
-		if ok, err := forcePush(*useRemote, br.Name); err != nil {
-			log.Fatalf("Updating %q: %v", br.Name, err)
-		} else if ok {
+		switch st, err := forcePush(*useRemote, br.Name, lease); {
+		case err != nil:
+			res.add(br.Name, skipped, "push failed: "+err.Error())
+		case st == pushUpToDate:
+			res.add(br.Name, succeeded, "rebased, already pushed")
+		case st == pushStale:
+			log.Printf("- Not updating %q: %s/%s has changed since %.12s", br.Name, *useRemote, br.Name, lease)
+			res.add(br.Name, remoteMoved, "fetch and review the remote changes")
+		default:
 			log.Printf("- Forced update of %q to %s", br.Name, *useRemote)
+			res.add(br.Name, succeeded, "rebased and pushed")
 		}
 	}
+	res.log()
+	if n := res.failed(); n != 0 {
+		return fmt.Errorf("%d of %d branches not updated", n, len(rem))
+	}
+	return nil
+}
+
+// rebaseBranch rebases branch onto base, according to the -on-conflict
+// policy.
+func rebaseBranch(base, branch string) error {
+	args := []string{base, branch}
+	if *onConflict == "stash" {
+		args = append([]string{"--autostash"}, args...)
+	}
+	_, err := git("rebase", args...)
+	return err
+}
+
+// rebaseInProgress reports whether a rebase has stopped, e.g., for conflicts.
+func rebaseInProgress() bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		path, err := git("rev-parse", "--git-path", dir)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 func currentBranch() (string, error) { return git("branch", "--show-current") }
 
-func forcePush(remote, branch string) (bool, error) {
-	bits, err := exec.Command("git", "push", "-f", remote, branch).CombinedOutput()
-	msg := string(bits)
+// A pushStatus is the result of pushing a branch.
+type pushStatus int
+
+const (
+	pushUpdated  pushStatus = iota // the remote branch was updated
+	pushUpToDate                   // the remote branch already matched
+	pushStale                      // the remote branch did not match the lease
+)
+
+// forcePush force-pushes branch to remote, provided that the remote branch is
+// still at lease (a commit hash), so that commits pushed by others since then
+// are not discarded. The outcome is read from the porcelain output of git
+// push, which is stable across versions and locales.
+func forcePush(remote, branch, lease string) (pushStatus, error) {
+	ref := "refs/heads/" + branch
+	args := []string{"push", "--porcelain", "--force-with-lease=" + ref + ":" + lease, remote, ref + ":" + ref}
+	if *doVerbose {
+		log.Println("[git]", shell.Join(args))
+	}
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+
+	// Each updated ref is reported as a line "<flag>\t<from>:<to>\t<summary>".
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 || !strings.HasSuffix(parts[1], ":"+ref) {
+			continue
+		}
+		switch parts[0] {
+		case "=":
+			return pushUpToDate, nil
+		case " ", "+", "*":
+			return pushUpdated, nil
+		case "!":
+			if strings.Contains(parts[2], "stale info") {
+				return pushStale, nil
+			}
+			return 0, fmt.Errorf("rejected: %s", parts[2])
+		}
+	}
 	if err != nil {
-		return false, errors.New(strings.SplitN(msg, "\n", 2)[0])
+		return 0, errors.New(strings.SplitN(strings.TrimSpace(stderr.String()), "\n", 2)[0])
 	}
-	ok := strings.ToLower(strings.TrimSpace(msg)) != "everything up-to-date"
-	return ok, nil
+	return 0, fmt.Errorf("no status reported for %s", ref)
 }
 
 type branchInfo struct {
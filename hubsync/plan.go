@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// printPlan prints what a sync would do to the default branch dbranch and the
+// selected branches, without changing anything. It does not fetch, but asks
+// the remote for the current tips of its branches, and flags any that differ
+// from the remote-tracking branches. If the default branch has moved to
+// commits not yet fetched, the outcome for each branch is reported as unknown.
+// If the remote cannot be reached, the plan reflects the remote-tracking
+// branches as of the last fetch.
+func printPlan(dbranch, remote string, branches []*branchInfo) error {
+	heads, lsErr := lsRemoteHeads(remote)
+	if lsErr != nil {
+		fmt.Printf("Warning: cannot query %s (%v); this plan reflects the last fetch (%s) and may be out of date\n",
+			remote, lsErr, lastFetch())
+	} else {
+		fmt.Printf("Checked the branches of %s; last fetched %s\n", remote, lastFetch())
+	}
+
+	// remoteRev returns the commit to use for the remote branch name, or ""
+	// if it does not exist, and a note if the plan for it may be inaccurate.
+	remoteRev := func(name string) (rev string, stale bool, note string) {
+		tracking, _ := git("rev-parse", "--verify", "--quiet", "refs/remotes/"+remote+"/"+name)
+		if lsErr != nil {
+			return tracking, false, ""
+		}
+		actual, ok := heads[name]
+		switch {
+		case !ok:
+			if tracking != "" {
+				return "", false, fmt.Sprintf("%s/%s has been deleted on the remote", remote, name)
+			}
+			return "", false, ""
+		case actual == tracking:
+			return actual, false, ""
+		case refExists(actual + "^{commit}"):
+			return actual, false, fmt.Sprintf("%s/%s has moved to %.12s since the last fetch", remote, name, actual)
+		default:
+			return tracking, true, fmt.Sprintf("%s/%s has moved to %.12s, which has not been fetched; counts are as of the last fetch",
+				remote, name, actual)
+		}
+	}
+
+	// After the pull, the default branch will be at the tip of its remote
+	// branch (barring local commits, which the pull would merge).
+	//
+	// If the remote branch has moved to commits we have not fetched, we cannot
+	// tell what the pull will bring in, and hence what will happen to the
+	// other branches either.
+	tip, baseStale, note := remoteRev(dbranch)
+	if tip == "" {
+		tip = dbranch
+	}
+	ahead, behind, err := aheadBehind(dbranch, tip)
+	if err != nil {
+		return fmt.Errorf("comparing %q to %q: %w", dbranch, tip, err)
+	}
+	if dirty, err := hasChanges(*stashInclude); err != nil {
+		return fmt.Errorf("checking for local changes: %w", err)
+	} else if dirty && *doAutostash {
+		fmt.Printf("Stash local changes (%s files) and restore them afterward\n", *stashInclude)
+	} else if dirty, _ := hasChanges("tracked"); dirty {
+		fmt.Println("Warning: the working tree has local changes, which may prevent the sync (see -autostash)")
+	}
+	if baseStale {
+		fmt.Printf("Pull %q from %s: unknown, the remote has commits not yet fetched (as of the last fetch: %s)\n",
+			dbranch, remote, pullSummary(ahead, behind))
+	} else {
+		fmt.Printf("Pull %q from %s: %s\n", dbranch, remote, pullSummary(ahead, behind))
+	}
+	if note != "" {
+		fmt.Println("  Warning:", note)
+	}
+	if len(branches) == 0 {
+		fmt.Println("No branches require update")
+		return nil
+	}
+
+	for _, br := range branches {
+		ahead, behind, err := aheadBehind(br.Name, tip)
+		if err != nil {
+			return fmt.Errorf("comparing %q to %q: %w", br.Name, tip, err)
+		}
+		action := "up to date with"
+		if behind > 0 {
+			action = "rebase onto"
+		}
+		line := fmt.Sprintf("Branch %q: %s %q (%d ahead, %d behind)", br.Name, action, dbranch, ahead, behind)
+		if baseStale {
+			line = fmt.Sprintf("Branch %q: rebase onto %q unknown until fetched (%d ahead, %d behind as of the last fetch)",
+				br.Name, dbranch, ahead, behind)
+		}
+
+		rev, stale, note := remoteRev(br.Name)
+		if *doForcePush {
+			unseen := 0
+			if rev != "" {
+				unseen, _ = countCommits("--cherry-pick", "--right-only", br.Name+"..."+rev)
+			}
+			switch {
+			case rev == "":
+				line += "; no remote branch to push"
+			case baseStale:
+				line += "; push unknown until fetched"
+			case stale:
+				line += "; push would likely be refused, since the remote has commits not yet fetched"
+			case unseen > 0:
+				line += fmt.Sprintf("; not pushed, %s/%s has %s not in the local branch", remote, br.Name, plural(unseen, "commit"))
+			case behind > 0 || !sameCommit(br.Name, rev):
+				line += "; force push to " + remote
+			default:
+				line += "; already pushed"
+			}
+		}
+		fmt.Println(line)
+		if note != "" {
+			fmt.Println("  Warning:", note)
+		}
+	}
+	return nil
+}
+
+// lsRemoteHeads returns the commit hashes of the branches of remote, as
+// reported by the remote itself.
+func lsRemoteHeads(remote string) (map[string]string, error) {
+	out, err := git("ls-remote", "--heads", remote)
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 {
+			heads[strings.TrimPrefix(f[1], "refs/heads/")] = f[0]
+		}
+	}
+	return heads, nil
+}
+
+// lastFetch describes when the repository was last fetched.
+func lastFetch() string {
+	path, err := git("rev-parse", "--git-path", "FETCH_HEAD")
+	if err != nil {
+		return "unknown"
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "never"
+	}
+	t := fi.ModTime()
+	return fmt.Sprintf("%s, %s ago", t.Format("2006-01-02 15:04:05"), time.Since(t).Round(time.Second))
+}
+
+// pullSummary describes the effect of a pull on a branch that is ahead and
+// behind its upstream by the given numbers of commits.
+func pullSummary(ahead, behind int) string {
+	if behind == 0 {
+		return "up to date"
+	}
+	s := plural(behind, "new commit")
+	if ahead > 0 {
+		s += fmt.Sprintf(", to merge with %s", plural(ahead, "local commit"))
+	}
+	return s
+}
+
+// aheadBehind reports the number of commits reachable from branch but not
+// base, and vice versa.
+func aheadBehind(branch, base string) (ahead, behind int, err error) {
+	if ahead, err = countCommits(base + ".." + branch); err != nil {
+		return 0, 0, err
+	}
+	if behind, err = countCommits(branch + ".." + base); err != nil {
+		return 0, 0, err
+	}
+	return ahead, behind, nil
+}
+
+// countCommits returns the number of commits listed by git rev-list with the
+// given arguments.
+func countCommits(args ...string) (int, error) {
+	out, err := git("rev-list", append([]string{"--count"}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+func refExists(ref string) bool {
+	_, err := git("rev-parse", "--verify", "--quiet", ref)
+	return err == nil
+}
+
+func sameCommit(a, b string) bool {
+	ha, err := git("rev-parse", "--verify", "--quiet", a+"^{commit}")
+	if err != nil {
+		return false
+	}
+	hb, err := git("rev-parse", "--verify", "--quiet", b+"^{commit}")
+	return err == nil && ha == hb
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// An outcome is the result of syncing a branch.
+type outcome int
+
+const (
+	succeeded outcome = iota
+	skipped
+	conflicted
+	remoteMoved
+)
+
+func (o outcome) String() string {
+	switch o {
+	case succeeded:
+		return "Succeeded"
+	case skipped:
+		return "Skipped"
+	case conflicted:
+		return "Conflicted"
+	case remoteMoved:
+		return "Remote moved"
+	}
+	return "Unknown"
+}
+
+// A result records the outcome of syncing one branch.
+type result struct {
+	Branch  string
+	Outcome outcome
+	Note    string
+}
+
+// results accumulates the results of a sync, in order.
+type results []result
+
+func (r *results) add(branch string, o outcome, note string) {
+	*r = append(*r, result{Branch: branch, Outcome: o, Note: note})
+}
+
+// failed reports the number of branches that were not updated.
+func (r results) failed() int {
+	var n int
+	for _, res := range r {
+		if res.Outcome != succeeded {
+			n++
+		}
+	}
+	return n
+}
+
+// log logs a summary of r, grouped by outcome.
+func (r results) log() {
+	log.Print("Summary:")
+	for _, o := range []outcome{succeeded, skipped, conflicted, remoteMoved} {
+		var names []string
+		for _, res := range r {
+			if res.Outcome == o {
+				names = append(names, res.Branch+" ("+res.Note+")")
+			}
+		}
+		if len(names) != 0 {
+			log.Printf("- %s: %s", o, strings.Join(names, ", "))
+		}
+	}
+}
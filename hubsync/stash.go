@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// statusArgs returns arguments to git status selecting the files included by
+// the -stash-include policy.
+func statusArgs(include string) []string {
+	switch include {
+	case "tracked":
+		return []string{"--untracked-files=no"}
+	case "all":
+		return []string{"--untracked-files=all", "--ignored"}
+	}
+	return []string{"--untracked-files=all"}
+}
+
+// hasChanges reports whether the working tree has changes to the files
+// selected by include (tracked, untracked, or all).
+func hasChanges(include string) (bool, error) {
+	out, err := git("status", append([]string{"--porcelain"}, statusArgs(include)...)...)
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// stashChanges stashes changes to the files selected by include, with the
+// given message, and returns the commit hash of the stash. It returns "" if
+// nothing was stashed, which may happen even if hasChanges reports changes,
+// for example if the only change is to the checked-out commit of a submodule.
+func stashChanges(include, msg string) (string, error) {
+	args := []string{"push", "--message", msg}
+	switch include {
+	case "untracked":
+		args = append(args, "--include-untracked")
+	case "all":
+		args = append(args, "--all")
+	}
+
+	// Compare the top of the stash before and after, so that we never mistake
+	// an existing stash for our own.
+	before, _ := git("rev-parse", "--verify", "--quiet", "refs/stash")
+	if _, err := git("stash", args...); err != nil {
+		return "", err
+	}
+	after, _ := git("rev-parse", "--verify", "--quiet", "refs/stash")
+	if after == before {
+		return "", nil
+	}
+	return after, nil
+}
+
+// stashName returns the name of the stash entry with the given commit hash,
+// for example "stash@{0}".
+func stashName(hash string) (string, error) {
+	out, err := git("stash", "list", "--format=%H")
+	if err != nil {
+		return "", err
+	}
+	for i, h := range strings.Split(out, "\n") {
+		if h == hash {
+			return fmt.Sprintf("stash@{%d}", i), nil
+		}
+	}
+	return "", fmt.Errorf("stash %.12s not found", hash)
+}
+
+// popStash restores the stashed changes with the given hash. If they do not
+// apply cleanly, the stash entry is kept and the error describes what the
+// user must do.
+func popStash(hash string) error {
+	name, err := stashName(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := git("stash", "pop", name); err != nil {
+		if files, _ := git("diff", "--name-only", "--diff-filter=U"); files != "" {
+			return fmt.Errorf("restoring local changes conflicted in %s; resolve the conflicts, then run: git stash drop %s",
+				strings.Join(strings.Split(files, "\n"), ", "), name)
+		}
+		return fmt.Errorf("restoring local changes: %v; they are saved as %s (git stash pop %s)", err, name, name)
+	}
+	return nil
+}
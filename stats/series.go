@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A series aggregates values into fixed-width buckets by timestamp.
+type series struct {
+	width   time.Duration
+	fill    bool              // include empty buckets between nonempty ones
+	buckets map[int64]*bucket // keyed by bucket start (Unix nanoseconds)
+}
+
+// maxFill is the largest number of buckets a series will produce when it
+// fills gaps, so that a stray timestamp far from the rest does not exhaust
+// memory.
+const maxFill = 100000
+
+func newSeries(width time.Duration, fill bool) *series {
+	return &series{width: width, fill: fill, buckets: make(map[int64]*bucket)}
+}
+
+// Add adds v to the bucket containing time t. Buckets are aligned to the Unix
+// epoch, so that for example hourly buckets start on the hour in UTC.
+func (s *series) Add(t time.Time, v *big.Rat) {
+	ns, w := t.UnixNano(), s.width.Nanoseconds()
+	off := ns % w
+	if off < 0 {
+		off += w
+	}
+	key := ns - off
+	b, ok := s.buckets[key]
+	if !ok {
+		b = new(bucket)
+		s.buckets[key] = b
+	}
+	b.Add(v)
+}
+
+// Buckets returns the nonempty buckets of s in order of increasing start
+// time. If s fills gaps, empty buckets between the first and last nonempty
+// buckets are included, so the result is a contiguous series; it is an error
+// if that would produce more than maxFill buckets.
+func (s *series) Buckets() ([]*bucket, error) {
+	if len(s.buckets) == 0 {
+		return nil, nil
+	}
+	keys := make([]int64, 0, len(s.buckets))
+	for key := range s.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var out []*bucket
+	if !s.fill {
+		for _, key := range keys {
+			b := s.buckets[key]
+			b.Start = time.Unix(0, key)
+			out = append(out, b)
+		}
+		return out, nil
+	}
+
+	step := s.width.Nanoseconds()
+	first, last := keys[0], keys[len(keys)-1]
+	if n := (last-first)/step + 1; n > maxFill || n <= 0 {
+		return nil, fmt.Errorf("filling gaps from %s to %s would produce too many buckets (limit %d)",
+			time.Unix(0, first).UTC().Format(time.RFC3339), time.Unix(0, last).UTC().Format(time.RFC3339), maxFill)
+	}
+	for key := first; key <= last; key += step {
+		b, ok := s.buckets[key]
+		if !ok {
+			b = new(bucket)
+		}
+		b.Start = time.Unix(0, key)
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// Write renders the buckets of s to w in the given format ("table" or
+// "json"), reporting the specified percentiles for each.
+func (s *series) Write(w io.Writer, format string, pcts []float64) error {
+	bs, err := s.Buckets()
+	if err != nil {
+		return err
+	}
+	secs := big.NewRat(s.width.Nanoseconds(), int64(time.Second))
+	switch format {
+	case "json":
+		type row struct {
+			Start time.Time               `json:"start"`
+			Count int64                   `json:"count"`
+			Mean  *json.Number            `json:"mean"` // nil if empty
+			Rate  json.Number             `json:"rate"`
+			Pcts  map[string]*json.Number `json:"percentiles,omitempty"`
+		}
+		rows := make([]row, 0, len(bs))
+		for _, b := range bs {
+			r := row{
+				Start: b.Start.UTC(),
+				Count: b.Count(),
+				Mean:  jsonNumber(b.Mean()),
+				Rate:  json.Number(rateString(b.Rate(secs))),
+				Pcts:  make(map[string]*json.Number),
+			}
+			for _, p := range pcts {
+				r.Pcts[pctLabel(p)] = jsonNumber(b.Percentile(p))
+			}
+			rows = append(rows, r)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprint(tw, "BUCKET\tCOUNT\tMEAN")
+		for _, p := range pcts {
+			fmt.Fprintf(tw, "\t%s", strings.ToUpper(pctLabel(p)))
+		}
+		fmt.Fprintln(tw, "\tRATE/s")
+		for _, b := range bs {
+			fmt.Fprintf(tw, "%s\t%d\t%s", b.Start.UTC().Format(time.RFC3339), b.Count(), cellString(b.Mean()))
+			for _, p := range pcts {
+				fmt.Fprintf(tw, "\t%s", cellString(b.Percentile(p)))
+			}
+			fmt.Fprintf(tw, "\t%s\n", rateString(b.Rate(secs)))
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// A bucket holds the statistics and values for a single time interval.
+type bucket struct {
+	stats
+	Start  time.Time
+	values []*big.Rat
+	sorted bool
+}
+
+// Add adds v to the bucket.
+func (b *bucket) Add(v *big.Rat) {
+	b.stats.Add(v)
+	b.values = append(b.values, v)
+	b.sorted = false
+}
+
+// Rate returns the number of values per second in a bucket of the given
+// width, in seconds.
+func (b *bucket) Rate(secs *big.Rat) *big.Rat {
+	r := big.NewRat(b.count, 1)
+	return r.Quo(r, secs)
+}
+
+// Percentile returns the nearest-rank pth percentile of the values in b, for
+// 0 < p ≤ 100. Returns nil if b is empty.
+func (b *bucket) Percentile(p float64) *big.Rat {
+	if len(b.values) == 0 {
+		return nil
+	}
+	if !b.sorted {
+		sort.Slice(b.values, func(i, j int) bool { return b.values[i].Cmp(b.values[j]) < 0 })
+		b.sorted = true
+	}
+	rank := int(p/100*float64(len(b.values))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(b.values) {
+		rank = len(b.values) - 1
+	}
+	return b.values[rank]
+}
+
+// parsePercentiles parses a comma-separated list of percentiles.
+func parsePercentiles(s string) ([]float64, error) {
+	var out []float64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		} else if v <= 0 || v > 100 {
+			return nil, fmt.Errorf("percentile %v out of range (0, 100]", v)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// rateString formats a rate, which is often fractional even when the values
+// are not, with at least 3 digits of precision, and enough more that a small
+// nonzero rate shows at least 2 significant digits rather than 0.
+func rateString(r *big.Rat) string {
+	if r.IsInt() {
+		return r.RatString()
+	}
+	digits := *precision
+	if digits < 3 {
+		digits = 3
+	}
+	if f, _ := r.Float64(); f != 0 {
+		if need := 1 - int(math.Floor(math.Log10(math.Abs(f)))); need > digits {
+			digits = need
+		}
+	}
+	return r.FloatString(digits)
+}
+
+// cellString formats r for a table, or "-" if r == nil.
+func cellString(r *big.Rat) string {
+	if r == nil {
+		return "-"
+	}
+	return ratString(r)
+}
+
+// jsonNumber formats r for JSON, or returns nil if r == nil.
+func jsonNumber(r *big.Rat) *json.Number {
+	if r == nil {
+		return nil
+	}
+	n := json.Number(ratString(r))
+	return &n
+}
+
+func pctLabel(p float64) string { return "p" + strconv.FormatFloat(p, 'f', -1, 64) }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeriesBuckets(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	add := func(s *series) {
+		s.Add(t0.Add(10*time.Second), big.NewRat(1, 1))
+		s.Add(t0.Add(3*time.Minute+5*time.Second), big.NewRat(3, 1))
+		s.Add(t0.Add(50*time.Second), big.NewRat(2, 1))
+	}
+	tests := []struct {
+		fill   bool
+		starts []time.Duration // offsets from t0
+		counts []int64
+	}{
+		{false, []time.Duration{0, 3 * time.Minute}, []int64{2, 1}},
+		{true, []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}, []int64{2, 0, 0, 1}},
+	}
+	for _, test := range tests {
+		s := newSeries(time.Minute, test.fill)
+		add(s)
+		bs, err := s.Buckets()
+		if err != nil {
+			t.Errorf("Buckets (fill=%v): unexpected error: %v", test.fill, err)
+			continue
+		}
+		var starts []time.Duration
+		var counts []int64
+		for _, b := range bs {
+			starts = append(starts, b.Start.Sub(t0))
+			counts = append(counts, b.Count())
+		}
+		if !reflect.DeepEqual(starts, test.starts) || !reflect.DeepEqual(counts, test.counts) {
+			t.Errorf("Buckets (fill=%v): got starts %v counts %v, want %v %v",
+				test.fill, starts, counts, test.starts, test.counts)
+		}
+	}
+
+	// A stray timestamp far from the rest does not produce a bucket for
+	// every interval in between.
+	s := newSeries(time.Second, false)
+	s.Add(time.Unix(0, 0), big.NewRat(1, 1))
+	s.Add(t0, big.NewRat(1, 1))
+	if bs, err := s.Buckets(); err != nil || len(bs) != 2 {
+		t.Errorf("Buckets: got %d buckets, %v; want 2, nil", len(bs), err)
+	}
+	s.fill = true
+	if bs, err := s.Buckets(); err == nil {
+		t.Errorf("Buckets (fill): got %d buckets, want error", len(bs))
+	}
+}
+
+func TestSeriesWrite(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	s := newSeries(time.Minute, true)
+	for _, v := range []int64{4, 1, 3, 2} {
+		s.Add(t0, big.NewRat(v, 1))
+	}
+	s.Add(t0.Add(2*time.Minute), big.NewRat(9, 1))
+	pcts := []float64{50, 99}
+
+	var buf bytes.Buffer
+	if err := s.Write(&buf, "table", pcts); err != nil {
+		t.Fatalf("Write table: %v", err)
+	}
+	want := []string{
+		"BUCKET                COUNT  MEAN  P50  P99  RATE/s",
+		"2020-01-02T03:04:00Z  4      2.5   2    4    0.067",
+		"2020-01-02T03:05:00Z  0      -     -    -    0",
+		"2020-01-02T03:06:00Z  1      9     9    9    0.017",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Write table:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	buf.Reset()
+	if err := s.Write(&buf, "json", pcts); err != nil {
+		t.Fatalf("Write json: %v", err)
+	}
+	var rows []struct {
+		Count int64
+		Mean  *float64
+		Pcts  map[string]*float64 `json:"percentiles"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("Decoding JSON output: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Write json: got %d rows, want 3", len(rows))
+	}
+	if r := rows[0]; r.Mean == nil || *r.Mean != 2.5 || r.Pcts["p50"] == nil || *r.Pcts["p50"] != 2 {
+		t.Errorf("Write json: row 0 is %+v, want mean 2.5 and p50 2", r)
+	}
+	if r := rows[1]; r.Count != 0 || r.Mean != nil || r.Pcts["p50"] != nil || r.Pcts["p99"] != nil {
+		t.Errorf("Write json: empty row is %+v, want null mean and percentiles", r)
+	}
+	if !strings.Contains(buf.String(), `"mean": null`) {
+		t.Errorf("Write json: missing null mean in:\n%s", buf.String())
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []float64
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"50", []float64{50}},
+		{"50,90,99", []float64{50, 90, 99}},
+		{" 99.9 , 100 ,", []float64{99.9, 100}},
+	}
+	for _, test := range tests {
+		got, err := parsePercentiles(test.input)
+		if err != nil {
+			t.Errorf("parsePercentiles(%q): unexpected error: %v", test.input, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parsePercentiles(%q): got %v, want %v", test.input, got, test.want)
+		}
+	}
+	for _, bad := range []string{"0", "-5", "101", "fifty", "50,x"} {
+		if got, err := parsePercentiles(bad); err == nil {
+			t.Errorf("parsePercentiles(%q): got %v, want error", bad, got)
+		}
+	}
+}
+
+func TestSeriesAlignment(t *testing.T) {
+	tests := []struct {
+		width time.Duration
+		at    string
+		start string
+	}{
+		{time.Minute, "2020-01-01T00:00:59Z", "2020-01-01T00:00:00Z"},
+		{time.Hour, "2020-01-01T05:30:00Z", "2020-01-01T05:00:00Z"},
+		{7 * time.Hour, "2020-01-01T00:00:05Z", "2019-12-31T20:00:00Z"}, // 438288h = 62612 × 7h + 4h
+		{24 * time.Hour, "2020-01-01T23:59:59Z", "2020-01-01T00:00:00Z"},
+		{time.Hour, "1969-12-31T23:30:00Z", "1969-12-31T23:00:00Z"}, // before the epoch
+	}
+	for _, test := range tests {
+		at, err := time.Parse(time.RFC3339, test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newSeries(test.width, false)
+		s.Add(at, big.NewRat(1, 1))
+		bs, err := s.Buckets()
+		if err != nil || len(bs) != 1 {
+			t.Fatalf("Buckets: got %d, %v; want 1 bucket", len(bs), err)
+		}
+		if got := bs[0].Start.UTC().Format(time.RFC3339); got != test.start {
+			t.Errorf("Add(%s) with width %v: bucket starts %s, want %s", test.at, test.width, got, test.start)
+		}
+	}
+}
+
+func TestRateString(t *testing.T) {
+	tests := []struct {
+		r    *big.Rat
+		want string
+	}{
+		{big.NewRat(0, 1), "0"},
+		{big.NewRat(5, 1), "5"},
+		{big.NewRat(1, 15), "0.067"},
+		{big.NewRat(1, 60), "0.017"},
+		{big.NewRat(1, 3600), "0.00028"},
+		{big.NewRat(1, 86400), "0.000012"},
+		{big.NewRat(3, 2), "1.500"},
+	}
+	for _, test := range tests {
+		if got := rateString(test.r); got != test.want {
+			t.Errorf("rateString(%v) = %q, want %q", test.r, got, test.want)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var (
@@ -25,6 +26,13 @@ var (
 	splitter  = flag.String("split", "", `Split input lines on this regexp ("" means don't split)`)
 	field     = flag.Int("field", 0, "Field to select (1-based; use 0 for the entire line)")
 	precision = flag.Int("prec", 1, "Number of digits of precision for fractional values")
+
+	timeField  = flag.Int("time", 0, "Field containing a timestamp to bucket by (1-based; 0 disables)")
+	timeLayout = flag.String("layout", time.RFC3339, `Layout of the -time field ("unix" for epoch seconds)`)
+	bucketSize = flag.Duration("bucket", time.Minute, "Width of time buckets when -time is set")
+	fillGaps   = flag.Bool("fill", false, "Include empty buckets between the first and last when -time is set")
+	pctList    = flag.String("pct", "50,90,99", "Comma-separated percentiles to report per bucket")
+	outFormat  = flag.String("format", "table", `Time series output format ("table" or "json")`)
 )
 
 func init() {
@@ -35,6 +43,11 @@ Print basic statistics on a column of values read from the given input files.
 If no files are specified, input is read from stdin.  Files are read in the
 order specified; use the special name "-" to read from stdin explicitly.
 
+If -time is set, values are grouped into buckets of width -bucket by the
+timestamp in the selected field (which requires -split), and a time series of
+per-bucket count, mean, percentiles, and rate is printed instead. Only
+nonempty buckets are printed unless -fill is set.
+
 Options:`)
 		flag.PrintDefaults()
 	}
@@ -94,15 +107,10 @@ type picker struct {
 
 // Pick returns the value selected by the current settings from s.
 func (p picker) Pick(s string) (*big.Rat, error) {
-	var field string
-	if p.Regexp == nil || p.field <= 0 {
-		field = strings.TrimSpace(s)
-	} else if fields := p.Split(s, -1); len(fields) < p.field {
-		return nil, fmt.Errorf("field %d out of range (%d found)", p.field, len(fields))
-	} else {
-		field = fields[p.field-1]
+	field, err := p.pickField(s, p.field)
+	if err != nil {
+		return nil, err
 	}
-
 	v, ok := big.NewRat(0, 1).SetString(field)
 	if ok {
 		return v, nil
@@ -110,6 +118,34 @@ func (p picker) Pick(s string) (*big.Rat, error) {
 	return nil, fmt.Errorf("invalid number format for %q", field)
 }
 
+// PickTime returns the timestamp in field n of s, parsed using layout.
+// The special layout "unix" denotes (possibly fractional) epoch seconds.
+func (p picker) PickTime(s string, n int, layout string) (time.Time, error) {
+	field, err := p.pickField(s, n)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if layout == "unix" {
+		v, ok := big.NewRat(0, 1).SetString(field)
+		if !ok {
+			return time.Time{}, fmt.Errorf("invalid epoch time %q", field)
+		}
+		ns := v.Mul(v, big.NewRat(int64(time.Second), 1))
+		return time.Unix(0, new(big.Int).Quo(ns.Num(), ns.Denom()).Int64()), nil
+	}
+	return time.Parse(layout, field)
+}
+
+func (p picker) pickField(s string, n int) (string, error) {
+	if p.Regexp == nil || n <= 0 {
+		return strings.TrimSpace(s), nil
+	} else if fields := p.Split(s, -1); len(fields) < n {
+		return "", fmt.Errorf("field %d out of range (%d found)", n, len(fields))
+	} else {
+		return fields[n-1], nil
+	}
+}
+
 func ratString(r *big.Rat) string {
 	if r == nil {
 		return "0"
@@ -129,8 +165,22 @@ func trim(s string) string {
 func main() {
 	flag.Parse()
 
+	if *timeField > 0 && *splitter == "" {
+		fail("You must specify -split to use -time")
+	}
+	pcts, err := parsePercentiles(*pctList)
+	if err != nil {
+		fail("Invalid -pct: %v", err)
+	}
+	if *outFormat != "table" && *outFormat != "json" {
+		fail("Invalid -format: %q", *outFormat)
+	}
+	if *bucketSize <= 0 {
+		fail("Invalid -bucket: %v", *bucketSize)
+	}
 	p := newPicker(*splitter, *field)
 	s := new(stats)
+	ts := newSeries(*bucketSize, *fillGaps)
 
 	var w *bufio.Writer
 	if *doCat {
@@ -166,6 +216,14 @@ func main() {
 				log.Printf("In %s: line %d: %v", path, ln, err)
 				continue
 			}
+			if *timeField > 0 {
+				t, err := p.PickTime(trim(line), *timeField, *timeLayout)
+				if err != nil {
+					log.Printf("In %s: line %d: invalid time: %v", path, ln, err)
+					continue
+				}
+				ts.Add(t, v)
+			}
 			s.Add(v)
 
 			if *doCat {
@@ -182,6 +240,17 @@ func main() {
 		}
 	}
 
+	if *timeField > 0 {
+		out := io.Writer(os.Stdout)
+		if *doCat {
+			out = os.Stderr
+		}
+		if err := ts.Write(out, *outFormat, pcts); err != nil {
+			fail("Output: %v", err)
+		}
+		return
+	}
+
 	out := []string{fmt.Sprintf("n=%d", s.Count())}
 	if *doSum {
 		out = append(out, fmt.Sprintf("sum=%v", ratString(s.Sum())))